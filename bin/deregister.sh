@@ -0,0 +1,3 @@
+#!/bin/bash
+
+curl -X DELETE http://127.0.0.1:8341/msd/deregister/aHR0cDovLzEyNy4wLjAuMTo4MC9ycw==
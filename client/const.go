@@ -1,6 +1,7 @@
 package client
 
 import (
+	"github.com/bluefw/blued/discoverd/api"
 	"github.com/hashicorp/serf/coordinate"
 	"github.com/hashicorp/serf/serf"
 	"net"
@@ -34,7 +35,17 @@ const (
 	getCoordinateCommand   = "get-coordinate"
 	listMicroAppsCommand   = "list-microapps"
 	listRoutersCommand     = "list-routers"
+	listTombstonesCommand  = "list-tombstones"
+	listConsumersCommand   = "list-consumers"
 	updateRoutersCommand   = "update-routers"
+	syncRoutersCommand     = "sync-routers"
+	maintenanceCommand     = "maintenance"
+	kvGetCommand           = "kv-get"
+	kvListCommand          = "kv-list"
+	kvPutCommand           = "kv-put"
+	kvDeleteCommand        = "kv-delete"
+	lookupCommand          = "lookup"
+	depsCommand            = "deps"
 )
 
 const (
@@ -44,6 +55,10 @@ const (
 	handshakeRequired     = "Handshake required"
 	monitorExists         = "Monitor already exists"
 	invalidFilter         = "Invalid event filter"
+	invalidSyncMode       = "Invalid router sync mode"
+	appNotRegistered      = "App is not registered"
+	kvKeyNotFound         = "Key not found"
+	serviceNotFound       = "Service not found"
 	streamExists          = "Stream with given sequence exists"
 	invalidQueryID        = "No pending queries matching ID"
 	authRequired          = "Authentication required"
@@ -157,6 +172,35 @@ type respondRequest struct {
 	Payload []byte
 }
 
+type syncRoutersRequest struct {
+	Routers []api.Router
+	Mode    string
+}
+
+type maintenanceRequest struct {
+	Addr   string
+	Enable bool
+	Reason string
+}
+
+type maintenanceResponse struct {
+	Addrs []string
+}
+
+type kvRequest struct {
+	Key   string
+	Value string
+}
+
+type kvListRequest struct {
+	Prefix  string
+	Deleted bool
+}
+
+type lookupRequest struct {
+	Service string
+}
+
 type queryRecord struct {
 	Type    string
 	From    string
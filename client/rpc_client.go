@@ -409,6 +409,33 @@ func (c *RPCClient) ListRouters() ([]api.Router, error) {
 	return resp, err
 }
 
+// ListTombstones returns the unregistrations remembered by the agent.
+func (c *RPCClient) ListTombstones() ([]api.InnerAppUnregister, error) {
+	header := requestHeader{
+		Command: listTombstonesCommand,
+		Seq:     c.getSeq(),
+	}
+	var resp []api.InnerAppUnregister
+
+	err := c.genericRPC(&header, nil, &resp)
+	return resp, err
+}
+
+// ListConsumers returns the services consumed by every app of the cluster,
+// as known by the agent.
+func (c *RPCClient) ListConsumers() ([]api.AppConsumers, error) {
+	header := requestHeader{
+		Command: listConsumersCommand,
+		Seq:     c.getSeq(),
+	}
+	var resp []api.AppConsumers
+
+	err := c.genericRPC(&header, nil, &resp)
+	return resp, err
+}
+
+// UpdateRouters replaces the router table of the agent with the given
+// routers.
 func (c *RPCClient) UpdateRouters(rs []api.Router) error {
 	header := requestHeader{
 		Command: updateRoutersCommand,
@@ -418,6 +445,146 @@ func (c *RPCClient) UpdateRouters(rs []api.Router) error {
 	return c.genericRPC(&header, rs, nil)
 }
 
+// SyncRouters syncs the router table of the agent with the given routers.
+// The mode is either api.SyncMerge or api.SyncReplace.
+func (c *RPCClient) SyncRouters(rs []api.Router, mode string) error {
+	header := requestHeader{
+		Command: syncRoutersCommand,
+		Seq:     c.getSeq(),
+	}
+	req := syncRoutersRequest{
+		Routers: rs,
+		Mode:    mode,
+	}
+
+	return c.genericRPC(&header, &req, nil)
+}
+
+// Maintenance puts the app at addr, or every app of the agent if addr is
+// empty, into maintenance with a reason, or takes them out of maintenance.
+// It returns the addrs of the apps.
+func (c *RPCClient) Maintenance(addr string, enable bool, reason string) ([]string, error) {
+	header := requestHeader{
+		Command: maintenanceCommand,
+		Seq:     c.getSeq(),
+	}
+	req := maintenanceRequest{
+		Addr:   addr,
+		Enable: enable,
+		Reason: reason,
+	}
+	var resp maintenanceResponse
+
+	err := c.genericRPC(&header, &req, &resp)
+	return resp.Addrs, err
+}
+
+// KVGet returns the entry of a key of the key/value store, nil if the key
+// is not set.
+func (c *RPCClient) KVGet(key string) (*api.KVEntry, error) {
+	header := requestHeader{
+		Command: kvGetCommand,
+		Seq:     c.getSeq(),
+	}
+	req := kvRequest{
+		Key: key,
+	}
+	var resp api.KVEntry
+
+	err := c.genericRPC(&header, &req, &resp)
+	if err != nil && err.Error() == kvKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// KVList returns the entries whose key starts with the prefix, including
+// the tombstones of deleted keys if deleted is set.
+func (c *RPCClient) KVList(prefix string, deleted bool) ([]api.KVEntry, error) {
+	header := requestHeader{
+		Command: kvListCommand,
+		Seq:     c.getSeq(),
+	}
+	req := kvListRequest{
+		Prefix:  prefix,
+		Deleted: deleted,
+	}
+	var resp []api.KVEntry
+
+	err := c.genericRPC(&header, &req, &resp)
+	return resp, err
+}
+
+// KVPut sets the value of a key and returns its new entry.
+func (c *RPCClient) KVPut(key string, value string) (*api.KVEntry, error) {
+	header := requestHeader{
+		Command: kvPutCommand,
+		Seq:     c.getSeq(),
+	}
+	req := kvRequest{
+		Key:   key,
+		Value: value,
+	}
+	var resp api.KVEntry
+
+	err := c.genericRPC(&header, &req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// KVDelete deletes a key.
+func (c *RPCClient) KVDelete(key string) error {
+	header := requestHeader{
+		Command: kvDeleteCommand,
+		Seq:     c.getSeq(),
+	}
+	req := kvRequest{
+		Key: key,
+	}
+
+	return c.genericRPC(&header, &req, nil)
+}
+
+// Lookup asks the cluster for the providers of a service and returns its
+// router once merged by the agent, nil if no peer provides it.
+func (c *RPCClient) Lookup(service string) (*api.Router, error) {
+	header := requestHeader{
+		Command: lookupCommand,
+		Seq:     c.getSeq(),
+	}
+	req := lookupRequest{
+		Service: service,
+	}
+	var resp api.Router
+
+	err := c.genericRPC(&header, &req, &resp)
+	if err != nil && err.Error() == serviceNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Dependencies returns the providers and consumers of every service of
+// the cluster.
+func (c *RPCClient) Dependencies() ([]api.ServiceDeps, error) {
+	header := requestHeader{
+		Command: depsCommand,
+		Seq:     c.getSeq(),
+	}
+	var resp []api.ServiceDeps
+
+	err := c.genericRPC(&header, nil, &resp)
+	return resp, err
+}
+
 type monitorHandler struct {
 	client *RPCClient
 	closed bool
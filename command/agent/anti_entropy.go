@@ -0,0 +1,238 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/bluefw/blued/client"
+	"github.com/bluefw/blued/discoverd"
+	"github.com/hashicorp/go-msgpack/codec"
+	"github.com/hashicorp/serf/serf"
+)
+
+const (
+	// AntiEntropyCommand is the query used to push/pull the router table
+	// with a peer. The payload is an antiEntropyRequest, the peer responds
+	// with its own RPC address and both sides pull the router table of the
+	// other one.
+	AntiEntropyCommand = "ae"
+
+	// antiEntropyTimeout bounds the query used to find the peer.
+	antiEntropyTimeout = 5 * time.Second
+)
+
+// antiEntropyRequest is the payload of the anti-entropy query. Node is
+// the name of the requester, its serf address replaces a loopback or
+// unspecified host of its RPC address.
+type antiEntropyRequest struct {
+	Node    string
+	RPCAddr string
+}
+
+// decodeAntiEntropyRequest decodes the payload of the anti-entropy query.
+// Older agents send their bare RPC address.
+func decodeAntiEntropyRequest(payload []byte) antiEntropyRequest {
+	var req antiEntropyRequest
+	dec := codec.NewDecoder(bytes.NewReader(payload), &codec.MsgpackHandle{})
+	if err := dec.Decode(&req); err != nil || req.RPCAddr == "" {
+		return antiEntropyRequest{RPCAddr: string(payload)}
+	}
+	return req
+}
+
+// AntiEntropy periodically merges the router table of the agent with
+// the one of a random peer, to heal divergences caused by lost user
+// events.
+type AntiEntropy struct {
+	agent     *Agent
+	discoverd *discoverd.Discoverd
+	config    *Config
+	logger    *log.Logger
+
+	triggerCh  chan struct{}
+	shutdownCh <-chan struct{}
+}
+
+// NewAntiEntropy creates the anti-entropy of the router table. It must be
+// started with Start.
+func NewAntiEntropy(agent *Agent, ds *discoverd.Discoverd, config *Config, logger *log.Logger) *AntiEntropy {
+	return &AntiEntropy{
+		agent:      agent,
+		discoverd:  ds,
+		config:     config,
+		logger:     logger,
+		triggerCh:  make(chan struct{}, 1),
+		shutdownCh: agent.ShutdownCh(),
+	}
+}
+
+// Start runs the anti-entropy until the agent shuts down. A first sync
+// is done right away.
+func (ae *AntiEntropy) Start() {
+	ae.Trigger()
+	go ae.run()
+}
+
+// Trigger asks for a sync as soon as possible. Triggers received while
+// a sync is pending are coalesced.
+func (ae *AntiEntropy) Trigger() {
+	select {
+	case ae.triggerCh <- struct{}{}:
+	default:
+	}
+}
+
+func (ae *AntiEntropy) run() {
+	ticker := time.NewTicker(ae.config.AntiEntropyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ae.triggerCh:
+		case <-ticker.C:
+		case <-ae.shutdownCh:
+			return
+		}
+
+		if err := ae.sync(); err != nil {
+			ae.logger.Printf("[WARN] ds.ae: Failed to sync router table: %v", err)
+		}
+	}
+}
+
+// sync push/pulls the router table with a random alive peer.
+func (ae *AntiEntropy) sync() error {
+	peer := ae.randomPeer()
+	if peer == "" {
+		ae.logger.Printf("[DEBUG] ds.ae: No peer to sync router table with")
+		return nil
+	}
+
+	params := &serf.QueryParam{
+		FilterNodes: []string{peer},
+		Timeout:     antiEntropyTimeout,
+	}
+	var buf bytes.Buffer
+	enc := codec.NewEncoder(&buf, &codec.MsgpackHandle{})
+	req := antiEntropyRequest{
+		Node:    ae.agent.Serf().LocalMember().Name,
+		RPCAddr: ae.config.RPCAddr,
+	}
+	if err := enc.Encode(&req); err != nil {
+		return err
+	}
+	resp, err := ae.agent.Query(AntiEntropyCommand, buf.Bytes(), params)
+	if err != nil {
+		return err
+	}
+
+	for r := range resp.ResponseCh() {
+		resp.Close()
+		addr, err := ae.peerRPCAddr(r.From, string(r.Payload))
+		if err != nil {
+			return err
+		}
+		return ae.Pull(addr)
+	}
+	return fmt.Errorf("no response from %s", peer)
+}
+
+// peerRPCAddr returns the address to reach the RPC of a peer listening
+// on addr. A loopback or unspecified host is replaced with the serf
+// address of the peer, as it can't be reached from the other nodes.
+func (ae *AntiEntropy) peerRPCAddr(node string, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(host)
+	local := host == "" || host == "localhost" || (ip != nil && (ip.IsLoopback() || ip.IsUnspecified()))
+	if !local || node == "" {
+		return addr, nil
+	}
+	for _, m := range ae.agent.Serf().Members() {
+		if m.Name == node {
+			return net.JoinHostPort(m.Addr.String(), port), nil
+		}
+	}
+	return "", fmt.Errorf("unknown member %s", node)
+}
+
+// Pull merges the router table, with its tombstones and the consumers of
+// the apps, and the key/value store of the agent listening on the RPC addr
+// into the local ones.
+func (ae *AntiEntropy) Pull(addr string) error {
+	cl, err := client.ClientFromConfig(&client.Config{
+		Addr:    addr,
+		AuthKey: ae.config.RPCAuthKey,
+	})
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	// the tombstones go first, so the routers they remove are not merged
+	ts, err := cl.ListTombstones()
+	if err != nil {
+		ae.logger.Printf("[WARN] ds.ae: Failed to list tombstones of %s: %v", addr, err)
+	} else {
+		ae.discoverd.MergeTombstones(ts)
+	}
+
+	rs, err := cl.ListRouters()
+	if err != nil {
+		return err
+	}
+	ae.logger.Printf("[INFO] ds.ae: Merging %d routers from %s", len(rs), addr)
+	ae.discoverd.MergeRouters(rs)
+
+	// agents predating the dependency graph don't list the consumers
+	cs, err := cl.ListConsumers()
+	if err != nil {
+		ae.logger.Printf("[WARN] ds.ae: Failed to list consumers of %s: %v", addr, err)
+	} else {
+		ae.discoverd.MergeConsumers(cs)
+	}
+
+	es, err := cl.KVList("", true)
+	if err != nil {
+		return err
+	}
+	ae.discoverd.KVMerge(es)
+	return nil
+}
+
+// IsSelfJoin tells whether a member join event joins the local node to
+// the cluster: either the local node is part of it, or every known peer
+// just joined.
+func (ae *AntiEntropy) IsSelfJoin(e serf.MemberEvent) bool {
+	local := ae.agent.Serf().LocalMember().Name
+	for _, m := range e.Members {
+		if m.Name == local {
+			return true
+		}
+	}
+	return len(ae.alivePeers()) <= len(e.Members)
+}
+
+func (ae *AntiEntropy) alivePeers() []string {
+	local := ae.agent.Serf().LocalMember().Name
+	var peers []string
+	for _, m := range ae.agent.Serf().Members() {
+		if m.Status == serf.StatusAlive && m.Name != local {
+			peers = append(peers, m.Name)
+		}
+	}
+	return peers
+}
+
+func (ae *AntiEntropy) randomPeer() string {
+	peers := ae.alivePeers()
+	if len(peers) == 0 {
+		return ""
+	}
+	return peers[rand.Intn(len(peers))]
+}
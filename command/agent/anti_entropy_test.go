@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+func TestAntiEntropy_decodeRequest(t *testing.T) {
+	var buf bytes.Buffer
+	enc := codec.NewEncoder(&buf, &codec.MsgpackHandle{})
+	if err := enc.Encode(&antiEntropyRequest{Node: "foo", RPCAddr: "0.0.0.0:7373"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	req := decodeAntiEntropyRequest(buf.Bytes())
+	if req.Node != "foo" || req.RPCAddr != "0.0.0.0:7373" {
+		t.Fatalf("bad: %#v", req)
+	}
+
+	// older agents send their bare RPC address
+	req = decodeAntiEntropyRequest([]byte("10.0.0.1:7373"))
+	if req.Node != "" || req.RPCAddr != "10.0.0.1:7373" {
+		t.Fatalf("bad: %#v", req)
+	}
+}
+
+func TestAntiEntropy_peerRPCAddr(t *testing.T) {
+	a1 := testAgent(nil)
+	defer a1.Shutdown()
+	if err := a1.Start(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	ae := NewAntiEntropy(a1, nil, DefaultConfig(), a1.logger)
+
+	local := a1.Serf().LocalMember()
+	expect := net.JoinHostPort(local.Addr.String(), "7373")
+	for _, addr := range []string{"127.0.0.1:7373", "0.0.0.0:7373", ":7373", "localhost:7373"} {
+		got, err := ae.peerRPCAddr(local.Name, addr)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if got != expect {
+			t.Fatalf("bad: %s for %s, expect: %s", got, addr, expect)
+		}
+	}
+
+	if got, _ := ae.peerRPCAddr(local.Name, "10.0.0.1:7373"); got != "10.0.0.1:7373" {
+		t.Fatalf("bad: %s", got)
+	}
+	if _, err := ae.peerRPCAddr("unknown", "127.0.0.1:7373"); err == nil {
+		t.Fatalf("expected error for an unknown member")
+	}
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/armon/go-metrics"
 	"github.com/bluefw/blued/discoverd"
+	"github.com/bluefw/blued/discoverd/util/prom"
 	"github.com/hashicorp/go-syslog"
 	"github.com/hashicorp/logutils"
 	"github.com/hashicorp/memberlist"
@@ -40,6 +41,7 @@ type Command struct {
 	args             []string
 	scriptHandler    *ScriptEventHandler
 	discoverdHandler *DiscoverdEventHandler
+	promSink         *prom.Sink
 	logFilter        *logutils.LevelFilter
 	logger           *log.Logger
 }
@@ -76,8 +78,12 @@ func (c *Command) readConfig() *Config {
 	cmdFlags.StringVar(&cmdConfig.RestAddr, "rest-addr", "",
 		"address to bind Rest Service listener to")
 	cmdFlags.IntVar(&cmdConfig.ServiceTTL, "service-ttl", 60, "ttl for micro app")
+	cmdFlags.StringVar(&cmdConfig.DNSAddr, "dns-addr", "",
+		"address to bind DNS listener to")
 	cmdFlags.StringVar(&cmdConfig.Profile, "profile", "", "timing profile to use (lan, wan, local)")
 	cmdFlags.StringVar(&cmdConfig.SnapshotPath, "snapshot", "", "path to the snapshot file")
+	cmdFlags.StringVar(&cmdConfig.DiscoverdDataDir, "discoverd-data-dir", "",
+		"directory to persist the micro apps to")
 	cmdFlags.Var((*AppendSliceValue)(&tags), "tag",
 		"tag pair, specified as key=value")
 	cmdFlags.StringVar(&cmdConfig.Discover, "discover", "", "mDNS discovery name")
@@ -417,16 +423,31 @@ func (c *Command) startAgent(config *Config, agent *Agent,
 
 	// Start discoverd server
 	c.Ui.Output("Starting Serf agent Discoverd...")
-	discoverd := discoverd.Create(config.RestAddr, config.ServiceTTL, agent.Serf(), logOutput)
+	dconf := config.DiscoverdConfig()
+	if c.promSink != nil {
+		dconf.Metrics = c.promSink
+	}
+	discoverd := discoverd.Create(dconf, agent.Serf(), logOutput)
 	ipc.SetDiscoverd(discoverd)
 
+	// Start the anti-entropy of the router table
+	antiEntropy := NewAntiEntropy(agent, discoverd, config, log.New(logOutput, "", log.LstdFlags))
+
 	// Register Blued Discoverd event handler
 	c.discoverdHandler = &DiscoverdEventHandler{
-		discoverd: discoverd,
-		config:    config,
-		logger:    log.New(logOutput, "", log.LstdFlags),
+		discoverd:   discoverd,
+		antiEntropy: antiEntropy,
+		config:      config,
+		logger:      log.New(logOutput, "", log.LstdFlags),
 	}
 	agent.RegisterEventHandler(c.discoverdHandler)
+	antiEntropy.Start()
+
+	// Register again the apps of the previous run, once their events can
+	// be handled
+	if err := discoverd.Restore(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to restore micro apps: %v", err))
+	}
 
 	c.Ui.Output("Serf agent running!")
 	c.Ui.Info(fmt.Sprintf("     Node name: '%s'", config.NodeName))
@@ -440,6 +461,9 @@ func (c *Command) startAgent(config *Config, agent *Agent,
 
 	c.Ui.Info(fmt.Sprintf("      RPC addr: '%s'", config.RPCAddr))
 	c.Ui.Info(fmt.Sprintf("     Rest addr: '%s'", config.RestAddr))
+	if config.DNSAddr != "" {
+		c.Ui.Info(fmt.Sprintf("      DNS addr: '%s'", config.DNSAddr))
+	}
 	c.Ui.Info(fmt.Sprintf("     Encrypted: %#v", agent.serf.EncryptionEnabled()))
 	c.Ui.Info(fmt.Sprintf("      Snapshot: %v", config.SnapshotPath != ""))
 	c.Ui.Info(fmt.Sprintf("       Profile: %s", config.Profile))
@@ -550,14 +574,14 @@ func (c *Command) Run(args []string) int {
 		fanout = append(fanout, sink)
 	}
 
-	// Initialize the global sink
-	if len(fanout) > 0 {
-		fanout = append(fanout, inm)
-		metrics.NewGlobal(metricsConf, fanout)
-	} else {
+	// Initialize the global sink, with the Prometheus sink served by
+	// discoverd on /metrics
+	if len(fanout) == 0 {
 		metricsConf.EnableHostname = false
-		metrics.NewGlobal(metricsConf, inm)
 	}
+	c.promSink = prom.NewSink()
+	fanout = append(fanout, inm, c.promSink)
+	metrics.NewGlobal(metricsConf, fanout)
 
 	// Setup serf
 	agent := c.setupAgent(config, logOutput)
@@ -636,10 +660,13 @@ WAIT:
 		return 1
 	}
 
-	// Attempt a graceful leave
+	// Attempt a graceful leave, unregistering the local apps first
 	gracefulCh := make(chan struct{})
 	c.Ui.Output("Gracefully shutting down agent...")
 	go func() {
+		if err := discoverd.Leave(); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error: %s", err))
+		}
 		if err := agent.Leave(); err != nil {
 			c.Ui.Error(fmt.Sprintf("Error: %s", err))
 			return
@@ -651,7 +678,7 @@ WAIT:
 	select {
 	case <-signalCh:
 		return 1
-	case <-time.After(gracefulTimeout):
+	case <-time.After(gracefulTimeout + config.LeaveDeregisterTimeout):
 		return 1
 	case <-gracefulCh:
 		return 0
@@ -719,6 +746,12 @@ Options:
                             from. This will read every file ending in ".json"
                             as configuration in this directory in alphabetical
                             order.
+  -dns-addr=127.0.0.1:8600  Address to bind the DNS listener, which answers A and
+                            SRV queries for <service>.service.blued. Disabled
+                            by default.
+  -discoverd-data-dir=path  Directory the micro apps registered on this agent
+                            are persisted to, so that they are registered again
+                            when the agent restarts.
   -discover=cluster        A cluster name used to discovery peers. On
                            networks that support multicast, this can be used to have
                            peers join each other without an explicit join.
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bluefw/blued/discoverd"
 	"github.com/hashicorp/serf/serf"
 	"github.com/mitchellh/mapstructure"
 )
@@ -22,19 +23,26 @@ const DefaultBindPort int = 7946
 // DefaultConfig contains the defaults for configurations.
 func DefaultConfig() *Config {
 	return &Config{
-		DisableCoordinates: false,
-		Tags:               make(map[string]string),
-		BindAddr:           "0.0.0.0",
-		AdvertiseAddr:      "",
-		LogLevel:           "INFO",
-		RPCAddr:            "127.0.0.1:7373",
-		RestAddr:           "127.0.0.1:8341",
-		ServiceTTL:         60,
-		Protocol:           serf.ProtocolVersionMax,
-		ReplayOnJoin:       false,
-		Profile:            "lan",
-		RetryInterval:      30 * time.Second,
-		SyslogFacility:     "LOCAL0",
+		DisableCoordinates:  false,
+		Tags:                make(map[string]string),
+		BindAddr:            "0.0.0.0",
+		AdvertiseAddr:       "",
+		LogLevel:            "INFO",
+		RPCAddr:             "127.0.0.1:7373",
+		RestAddr:            "127.0.0.1:8341",
+		ServiceTTL:          60,
+		Protocol:            serf.ProtocolVersionMax,
+		ReplayOnJoin:        false,
+		Profile:             "lan",
+		RetryInterval:       30 * time.Second,
+		AntiEntropyInterval: 60 * time.Second,
+		SyslogFacility:      "LOCAL0",
+
+		RouterTombstoneTimeout: 24 * time.Hour,
+		LeaveDeregisterTimeout: 5 * time.Second,
+		DNSTTL:                 5 * time.Second,
+		DNSOrder:               discoverd.DNSOrderRandom,
+		ZoneMinInstances:       1,
 	}
 }
 
@@ -124,6 +132,41 @@ type Config struct {
 	// are "wan", "lan", and "local". The default is "lan"
 	Profile string `mapstructure:"profile"`
 
+	// DiscoverdDataDir is the directory the micro apps registered on this
+	// agent are persisted to. On start, the agent registers them again
+	// unless their TTL lapsed meanwhile.
+	DiscoverdDataDir string `mapstructure:"discoverd_data_dir"`
+
+	// DNSAddr is the address and port to listen on for the agent's DNS
+	// interface, which answers A and SRV queries for <service>.service.blued.
+	// The DNS interface is disabled if empty.
+	DNSAddr string `mapstructure:"dns_addr"`
+
+	// DNSTTLRaw is the string TTL of the DNS answers. This defaults to
+	// 5 seconds.
+	DNSTTLRaw string        `mapstructure:"dns_ttl"`
+	DNSTTL    time.Duration `mapstructure:"-"`
+
+	// DNSOrder is the order of the DNS answers, "random" or "rtt" to sort
+	// them by the estimated round trip time. This defaults to "random".
+	DNSOrder string `mapstructure:"dns_order"`
+
+	// ZoneTag is the tag holding the zone of the nodes, such as "zone".
+	// If set, apps are routed to the providers on nodes in the same zone
+	// as their agent, unless that zone has fewer than ZoneMinInstances
+	// passing instances of a service. ZoneMinInstances defaults to 1.
+	ZoneTag          string `mapstructure:"zone_tag"`
+	ZoneMinInstances int    `mapstructure:"zone_min_instances"`
+
+	// EnableScriptChecks allows the apps to register health checks running
+	// a script on the node. Anyone able to reach the REST API can register,
+	// so this defaults to false.
+	EnableScriptChecks bool `mapstructure:"enable_script_checks"`
+
+	// DisableServiceMetrics turns off the gauge of the instances of every
+	// service, whose series grow with the number of services.
+	DisableServiceMetrics bool `mapstructure:"disable_service_metrics"`
+
 	// SnapshotPath is used to allow Serf to snapshot important transactional
 	// state to make a more graceful recovery possible. This enables auto
 	// re-joining a cluster on failure and avoids old message replay.
@@ -202,6 +245,27 @@ type Config struct {
 	// only has an affect if the snapshot file is enabled.
 	RejoinAfterLeave bool `mapstructure:"rejoin_after_leave"`
 
+	// AntiEntropyIntervalRaw is the string anti-entropy interval. Every
+	// interval the agent push/pulls its router table with a random peer,
+	// on top of the sync done when it joins a cluster. This defaults to
+	// 60 seconds.
+	AntiEntropyIntervalRaw string        `mapstructure:"anti_entropy_interval"`
+	AntiEntropyInterval    time.Duration `mapstructure:"-"`
+
+	// RouterTombstoneTimeoutRaw is the string router tombstone timeout. This
+	// timeout controls for how long the agent remembers an unregistered app,
+	// so that older registration events delivered late or replayed can't
+	// resurrect it. This defaults to 24 hours.
+	RouterTombstoneTimeoutRaw string        `mapstructure:"router_tombstone_timeout"`
+	RouterTombstoneTimeout    time.Duration `mapstructure:"-"`
+
+	// LeaveDeregisterTimeoutRaw is the string leave deregister timeout. On
+	// a graceful leave the agent unregisters its apps from the cluster and
+	// waits up to this timeout for the unregistrations to be broadcast
+	// before leaving. This defaults to 5 seconds.
+	LeaveDeregisterTimeoutRaw string        `mapstructure:"leave_deregister_timeout"`
+	LeaveDeregisterTimeout    time.Duration `mapstructure:"-"`
+
 	// StatsiteAddr is the address of a statsite instance. If provided,
 	// metrics will be streamed to that instance.
 	StatsiteAddr string `mapstructure:"statsite_addr"`
@@ -260,6 +324,31 @@ func (c *Config) NetworkInterface() (*net.Interface, error) {
 	return net.InterfaceByName(c.Interface)
 }
 
+// DiscoverdConfig returns the configuration of discoverd. The router table
+// is snapshotted next to the serf snapshot, if any.
+func (c *Config) DiscoverdConfig() *discoverd.Config {
+	conf := &discoverd.Config{
+		RestAddr:     c.RestAddr,
+		ServiceTTL:   c.ServiceTTL,
+		TombstoneTTL: c.RouterTombstoneTimeout,
+		LeaveTimeout: c.LeaveDeregisterTimeout,
+		DataDir:      c.DiscoverdDataDir,
+		DNSAddr:      c.DNSAddr,
+		DNSTTL:       c.DNSTTL,
+		DNSOrder:     c.DNSOrder,
+
+		ZoneTag:          c.ZoneTag,
+		ZoneMinInstances: c.ZoneMinInstances,
+
+		EnableScriptChecks:    c.EnableScriptChecks,
+		DisableServiceMetrics: c.DisableServiceMetrics,
+	}
+	if c.SnapshotPath != "" {
+		conf.RouterSnapshotPath = c.SnapshotPath + ".routers"
+	}
+	return conf
+}
+
 // DecodeConfig reads the configuration from the given reader in JSON
 // format and decodes it into a proper Config structure.
 func DecodeConfig(r io.Reader) (*Config, error) {
@@ -318,6 +407,38 @@ func DecodeConfig(r io.Reader) (*Config, error) {
 		result.RetryInterval = dur
 	}
 
+	if result.AntiEntropyIntervalRaw != "" {
+		dur, err := time.ParseDuration(result.AntiEntropyIntervalRaw)
+		if err != nil {
+			return nil, err
+		}
+		result.AntiEntropyInterval = dur
+	}
+
+	if result.DNSTTLRaw != "" {
+		dur, err := time.ParseDuration(result.DNSTTLRaw)
+		if err != nil {
+			return nil, err
+		}
+		result.DNSTTL = dur
+	}
+
+	if result.RouterTombstoneTimeoutRaw != "" {
+		dur, err := time.ParseDuration(result.RouterTombstoneTimeoutRaw)
+		if err != nil {
+			return nil, err
+		}
+		result.RouterTombstoneTimeout = dur
+	}
+
+	if result.LeaveDeregisterTimeoutRaw != "" {
+		dur, err := time.ParseDuration(result.LeaveDeregisterTimeoutRaw)
+		if err != nil {
+			return nil, err
+		}
+		result.LeaveDeregisterTimeout = dur
+	}
+
 	return &result, nil
 }
 
@@ -391,6 +512,30 @@ func MergeConfig(a, b *Config) *Config {
 	if b.SnapshotPath != "" {
 		result.SnapshotPath = b.SnapshotPath
 	}
+	if b.DiscoverdDataDir != "" {
+		result.DiscoverdDataDir = b.DiscoverdDataDir
+	}
+	if b.DNSAddr != "" {
+		result.DNSAddr = b.DNSAddr
+	}
+	if b.DNSTTL != 0 {
+		result.DNSTTL = b.DNSTTL
+	}
+	if b.DNSOrder != "" {
+		result.DNSOrder = b.DNSOrder
+	}
+	if b.ZoneTag != "" {
+		result.ZoneTag = b.ZoneTag
+	}
+	if b.ZoneMinInstances != 0 {
+		result.ZoneMinInstances = b.ZoneMinInstances
+	}
+	if b.EnableScriptChecks == true {
+		result.EnableScriptChecks = true
+	}
+	if b.DisableServiceMetrics == true {
+		result.DisableServiceMetrics = true
+	}
 	if b.LeaveOnTerm == true {
 		result.LeaveOnTerm = true
 	}
@@ -433,6 +578,15 @@ func MergeConfig(a, b *Config) *Config {
 	if b.RejoinAfterLeave {
 		result.RejoinAfterLeave = true
 	}
+	if b.AntiEntropyInterval != 0 {
+		result.AntiEntropyInterval = b.AntiEntropyInterval
+	}
+	if b.RouterTombstoneTimeout != 0 {
+		result.RouterTombstoneTimeout = b.RouterTombstoneTimeout
+	}
+	if b.LeaveDeregisterTimeout != 0 {
+		result.LeaveDeregisterTimeout = b.LeaveDeregisterTimeout
+	}
 	if b.SyslogFacility != "" {
 		result.SyslogFacility = b.SyslogFacility
 	}
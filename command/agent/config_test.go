@@ -300,6 +300,102 @@ func TestDecodeConfig(t *testing.T) {
 		t.Fatalf("bad: %#v", config)
 	}
 
+	// Anti-entropy configs
+	input = `{"anti_entropy_interval": "90s"}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if config.AntiEntropyInterval != 90*time.Second {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Discoverd data dir
+	input = `{"discoverd_data_dir": "/tmp/blued"}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if config.DiscoverdDataDir != "/tmp/blued" {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// DNS configs
+	input = `{"dns_addr": "127.0.0.1:8600", "dns_ttl": "30s", "dns_order": "rtt"}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if config.DNSAddr != "127.0.0.1:8600" {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	if config.DNSTTL != 30*time.Second {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	if config.DNSOrder != "rtt" {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Zone configs
+	input = `{"zone_tag": "zone", "zone_min_instances": 2}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if config.ZoneTag != "zone" || config.ZoneMinInstances != 2 {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Script checks
+	input = `{"enable_script_checks": true}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !config.EnableScriptChecks {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Service metrics
+	input = `{"disable_service_metrics": true}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !config.DisableServiceMetrics {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Router tombstone configs
+	input = `{"router_tombstone_timeout": "2h"}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if config.RouterTombstoneTimeout != 2*time.Hour {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Leave deregister configs
+	input = `{"leave_deregister_timeout": "10s"}`
+	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if config.LeaveDeregisterTimeout != 10*time.Second {
+		t.Fatalf("bad: %#v", config)
+	}
+
 	// Retry configs
 	input = `{"retry_join": ["127.0.0.1", "127.0.0.2"]}`
 	config, err = DecodeConfig(bytes.NewReader([]byte(input)))
@@ -366,27 +462,38 @@ func TestMergeConfig(t *testing.T) {
 	}
 
 	b := &Config{
-		NodeName:              "bname",
-		DisableCoordinates:    true,
-		Protocol:              -1,
-		EncryptKey:            "foo",
-		EventHandlers:         []string{"bar"},
-		StartJoin:             []string{"bar"},
-		LeaveOnTerm:           true,
-		SkipLeaveOnInt:        true,
-		Discover:              "tubez",
-		Interface:             "eth0",
-		ReconnectInterval:     15 * time.Second,
-		ReconnectTimeout:      48 * time.Hour,
-		RPCAuthKey:            "foobar",
-		DisableNameResolution: true,
-		TombstoneTimeout:      36 * time.Hour,
-		EnableSyslog:          true,
-		RetryJoin:             []string{"zip"},
-		RetryMaxAttempts:      10,
-		RetryInterval:         120 * time.Second,
-		RejoinAfterLeave:      true,
-		StatsiteAddr:          "127.0.0.1:8125",
+		NodeName:               "bname",
+		DisableCoordinates:     true,
+		Protocol:               -1,
+		EncryptKey:             "foo",
+		EventHandlers:          []string{"bar"},
+		StartJoin:              []string{"bar"},
+		LeaveOnTerm:            true,
+		SkipLeaveOnInt:         true,
+		Discover:               "tubez",
+		Interface:              "eth0",
+		ReconnectInterval:      15 * time.Second,
+		ReconnectTimeout:       48 * time.Hour,
+		RPCAuthKey:             "foobar",
+		DisableNameResolution:  true,
+		TombstoneTimeout:       36 * time.Hour,
+		EnableSyslog:           true,
+		RetryJoin:              []string{"zip"},
+		RetryMaxAttempts:       10,
+		RetryInterval:          120 * time.Second,
+		RejoinAfterLeave:       true,
+		AntiEntropyInterval:    30 * time.Second,
+		RouterTombstoneTimeout: 12 * time.Hour,
+		LeaveDeregisterTimeout: 20 * time.Second,
+		DiscoverdDataDir:       "/tmp/blued",
+		DNSAddr:                "127.0.0.1:8600",
+		DNSTTL:                 time.Minute,
+		DNSOrder:               "rtt",
+		ZoneTag:                "zone",
+		ZoneMinInstances:       3,
+		EnableScriptChecks:     true,
+		DisableServiceMetrics:  true,
+		StatsiteAddr:           "127.0.0.1:8125",
 	}
 
 	c := MergeConfig(a, b)
@@ -467,6 +574,34 @@ func TestMergeConfig(t *testing.T) {
 		t.Fatalf("bad: %#v", c)
 	}
 
+	if c.AntiEntropyInterval != 30*time.Second {
+		t.Fatalf("bad: %#v", c)
+	}
+
+	if c.RouterTombstoneTimeout != 12*time.Hour {
+		t.Fatalf("bad: %#v", c)
+	}
+
+	if c.LeaveDeregisterTimeout != 20*time.Second {
+		t.Fatalf("bad: %#v", c)
+	}
+
+	if c.DiscoverdDataDir != "/tmp/blued" {
+		t.Fatalf("bad: %#v", c)
+	}
+
+	if c.DNSAddr != "127.0.0.1:8600" || c.DNSTTL != time.Minute || c.DNSOrder != "rtt" {
+		t.Fatalf("bad: %#v", c)
+	}
+
+	if c.ZoneTag != "zone" || c.ZoneMinInstances != 3 {
+		t.Fatalf("bad: %#v", c)
+	}
+
+	if !c.EnableScriptChecks || !c.DisableServiceMetrics {
+		t.Fatalf("bad: %#v", c)
+	}
+
 	if c.StatsiteAddr != "127.0.0.1:8125" {
 		t.Fatalf("bad: %#v", c)
 	}
@@ -547,3 +682,26 @@ func TestReadConfigPaths_dir(t *testing.T) {
 		t.Fatalf("bad: %#v", config)
 	}
 }
+
+func TestConfigDiscoverdConfig(t *testing.T) {
+	c := DefaultConfig()
+	if conf := c.DiscoverdConfig(); conf.RouterSnapshotPath != "" {
+		t.Fatalf("bad: %#v", conf)
+	}
+
+	c.SnapshotPath = "/tmp/serf.snapshot"
+	c.DiscoverdDataDir = "/tmp/blued"
+	conf := c.DiscoverdConfig()
+	if conf.RouterSnapshotPath != "/tmp/serf.snapshot.routers" {
+		t.Fatalf("bad: %#v", conf)
+	}
+	if conf.DataDir != "/tmp/blued" || conf.TombstoneTTL != 24*time.Hour {
+		t.Fatalf("bad: %#v", conf)
+	}
+	if conf.ZoneTag != "" || conf.ZoneMinInstances != 1 || conf.LeaveTimeout != 5*time.Second {
+		t.Fatalf("bad: %#v", conf)
+	}
+	if conf.EnableScriptChecks || conf.DisableServiceMetrics {
+		t.Fatalf("bad: %#v", conf)
+	}
+}
@@ -2,8 +2,10 @@ package agent
 
 import (
 	"bytes"
+	"github.com/armon/go-metrics"
 	"github.com/bluefw/blued/discoverd"
 	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/cluster"
 	"github.com/hashicorp/go-msgpack/codec"
 	"github.com/hashicorp/serf/serf"
 	"log"
@@ -12,14 +14,16 @@ import (
 const (
 	RSCommand  = "rs"
 	URSCommand = "us"
+	KVCommand  = "kv"
 
 	QRPCAddrCommand = "qr"
 )
 
 type DiscoverdEventHandler struct {
-	discoverd *discoverd.Discoverd
-	config    *Config
-	logger    *log.Logger
+	discoverd   *discoverd.Discoverd
+	antiEntropy *AntiEntropy
+	config      *Config
+	logger      *log.Logger
 }
 
 func NewDiscoverdEventHandler(ds *discoverd.Discoverd, config *Config, logger *log.Logger) EventHandler {
@@ -31,25 +35,42 @@ func NewDiscoverdEventHandler(ds *discoverd.Discoverd, config *Config, logger *l
 }
 func (h *DiscoverdEventHandler) HandleEvent(e serf.Event) {
 	var err error
+	name := e.EventType().String()
 	switch event := e.(type) {
 	case serf.MemberEvent:
-		if event.EventType() == serf.EventMemberFailed {
+		if event.EventType() == serf.EventMemberJoin {
+			err = h.onMemberJoin(event)
+		} else if event.EventType() == serf.EventMemberFailed {
 			err = h.onMemberFaild(event)
 		} else if event.EventType() == serf.EventMemberLeave {
 			err = h.onMemberLeave(event)
 		}
 	case serf.UserEvent:
+		name = event.Name
 		err = h.onUserEvent(event)
 	case *serf.Query:
+		name = event.Name
 		err = h.onQuery(event)
 	default:
 		h.logger.Printf("[INFO] ds.event: Unknown event type: %s", e.EventType().String())
 	}
+	labels := []metrics.Label{{Name: "event", Value: name}}
 	if err != nil {
 		h.logger.Printf("[ERR] ds.event: Failed to handle event %v:%v", e, err)
+		metrics.IncrCounterWithLabels([]string{"discoverd", "events", "failed"}, 1, labels)
+	} else {
+		metrics.IncrCounterWithLabels([]string{"discoverd", "events", "handled"}, 1, labels)
 	}
 }
 
+func (h *DiscoverdEventHandler) onMemberJoin(e serf.MemberEvent) error {
+	if h.antiEntropy != nil && h.antiEntropy.IsSelfJoin(e) {
+		h.logger.Printf("[INFO] ds.event: Joined cluster, syncing router table.")
+		h.antiEntropy.Trigger()
+	}
+	return nil
+}
+
 func (h *DiscoverdEventHandler) onMemberFaild(e serf.MemberEvent) error {
 	h.logger.Printf("[INFO] ds.event: Handle member faild event.")
 	for _, m := range e.Members {
@@ -76,12 +97,23 @@ func (h *DiscoverdEventHandler) onUserEvent(e serf.UserEvent) error {
 		}
 		h.registerService(&ias)
 	case URSCommand:
-		var addr string
+		var iau api.InnerAppUnregister
 		dec := codec.NewDecoder(bytes.NewReader(e.Payload), &codec.MsgpackHandle{})
-		if err := dec.Decode(&addr); err != nil {
+		if err := dec.Decode(&iau); err != nil {
+			// agents without versioned registrations gossip the bare addr
+			dec = codec.NewDecoder(bytes.NewReader(e.Payload), &codec.MsgpackHandle{})
+			if err := dec.Decode(&iau.Addr); err != nil {
+				return err
+			}
+		}
+		h.unregisterService(&iau)
+	case KVCommand:
+		var entry api.KVEntry
+		dec := codec.NewDecoder(bytes.NewReader(e.Payload), &codec.MsgpackHandle{})
+		if err := dec.Decode(&entry); err != nil {
 			return err
 		}
-		h.unregisterService(addr)
+		h.discoverd.KVApply(entry)
 	}
 	return nil
 }
@@ -92,14 +124,44 @@ func (h *DiscoverdEventHandler) onQuery(e *serf.Query) error {
 	case QRPCAddrCommand:
 		h.logger.Printf("[INFO] rpc:%s ", h.config.RPCAddr)
 		e.Respond([]byte(h.config.RPCAddr))
+	case AntiEntropyCommand:
+		if err := e.Respond([]byte(h.config.RPCAddr)); err != nil {
+			return err
+		}
+		if h.antiEntropy != nil {
+			go func(req antiEntropyRequest) {
+				addr, err := h.antiEntropy.peerRPCAddr(req.Node, req.RPCAddr)
+				if err == nil {
+					err = h.antiEntropy.Pull(addr)
+				}
+				if err != nil {
+					h.logger.Printf("[WARN] ds.event: Failed to pull router table from %s: %v", req.RPCAddr, err)
+				}
+			}(decodeAntiEntropyRequest(e.Payload))
+		}
+	case cluster.LookupCommand:
+		// Only the peers running providers of the service answer.
+		lr, found := h.discoverd.LocalProviders(string(e.Payload))
+		if !found {
+			return nil
+		}
+		payload, err := cluster.EncodeLookupResponse(lr, h.config.NodeName)
+		if err != nil {
+			return err
+		}
+		return e.Respond(payload)
 	}
 
 	return nil
 }
 
 func (h *DiscoverdEventHandler) registerService(ias *api.InnerAppService) {
-	h.discoverd.AddRouter(ias.NodeAddr.Node, ias.NodeAddr.Addr, ias.Services)
+	if ias.ConsumersOmitted {
+		h.discoverd.AddRouterWithoutConsumers(ias.NodeAddr, ias.Services)
+		return
+	}
+	h.discoverd.AddRouter(ias.NodeAddr, ias.Services, ias.Consumers)
 }
-func (h *DiscoverdEventHandler) unregisterService(addr string) {
-	h.discoverd.RemoveRouter(addr)
+func (h *DiscoverdEventHandler) unregisterService(iau *api.InnerAppUnregister) {
+	h.discoverd.RemoveRouter(iau.Addr, iau.Version)
 }
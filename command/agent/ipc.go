@@ -69,7 +69,17 @@ const (
 	statsCommand           = "stats"
 	listMicroAppsCommand   = "list-microapps"
 	listRoutersCommand     = "list-routers"
+	listTombstonesCommand  = "list-tombstones"
+	listConsumersCommand   = "list-consumers"
 	updateRoutersCommand   = "update-routers"
+	syncRoutersCommand     = "sync-routers"
+	maintenanceCommand     = "maintenance"
+	kvGetCommand           = "kv-get"
+	kvListCommand          = "kv-list"
+	kvPutCommand           = "kv-put"
+	kvDeleteCommand        = "kv-delete"
+	lookupCommand          = "lookup"
+	depsCommand            = "deps"
 	getCoordinateCommand   = "get-coordinate"
 )
 
@@ -80,6 +90,10 @@ const (
 	handshakeRequired     = "Handshake required"
 	monitorExists         = "Monitor already exists"
 	invalidFilter         = "Invalid event filter"
+	invalidSyncMode       = "Invalid router sync mode"
+	appNotRegistered      = "App is not registered"
+	kvKeyNotFound         = "Key not found"
+	serviceNotFound       = "Service not found"
 	streamExists          = "Stream with given sequence exists"
 	invalidQueryID        = "No pending queries matching ID"
 	authRequired          = "Authentication required"
@@ -193,6 +207,35 @@ type respondRequest struct {
 	Payload []byte
 }
 
+type syncRoutersRequest struct {
+	Routers []api.Router
+	Mode    string
+}
+
+type maintenanceRequest struct {
+	Addr   string
+	Enable bool
+	Reason string
+}
+
+type maintenanceResponse struct {
+	Addrs []string
+}
+
+type kvRequest struct {
+	Key   string
+	Value string
+}
+
+type kvListRequest struct {
+	Prefix  string
+	Deleted bool
+}
+
+type lookupRequest struct {
+	Service string
+}
+
 type queryRecord struct {
 	Type    string
 	From    string
@@ -551,8 +594,38 @@ func (i *AgentIPC) handleRequest(client *IPCClient, reqHeader *requestHeader) er
 	case listRoutersCommand:
 		return i.handleListRouters(client, seq)
 
+	case listTombstonesCommand:
+		return i.handleListTombstones(client, seq)
+
+	case listConsumersCommand:
+		return i.handleListConsumers(client, seq)
+
 	case updateRoutersCommand:
 		return i.handleUpdateRouters(client, seq)
+
+	case syncRoutersCommand:
+		return i.handleSyncRouters(client, seq)
+
+	case maintenanceCommand:
+		return i.handleMaintenance(client, seq)
+
+	case kvGetCommand:
+		return i.handleKVGet(client, seq)
+
+	case kvListCommand:
+		return i.handleKVList(client, seq)
+
+	case kvPutCommand:
+		return i.handleKVPut(client, seq)
+
+	case kvDeleteCommand:
+		return i.handleKVDelete(client, seq)
+
+	case lookupCommand:
+		return i.handleLookup(client, seq)
+
+	case depsCommand:
+		return i.handleDeps(client, seq)
 		
 	case getCoordinateCommand:
 		return i.handleGetCoordinate(client, seq)
@@ -945,6 +1018,13 @@ func (i *AgentIPC) handleStop(client *IPCClient, seq uint64) error {
 func (i *AgentIPC) handleLeave(client *IPCClient, seq uint64) error {
 	i.logger.Printf("[INFO] agent.ipc: Graceful leave triggered")
 
+	// Unregister the local apps before leaving
+	if i.discoverd != nil {
+		if err := i.discoverd.Leave(); err != nil {
+			i.logger.Printf("[WARN] agent.ipc: %v", err)
+		}
+	}
+
 	// Do the leave
 	err := i.agent.Leave()
 	if err != nil {
@@ -1078,6 +1158,26 @@ func (i *AgentIPC) handleListRouters(client *IPCClient, seq uint64) error {
 	return client.Send(&header, resp)
 }
 
+func (i *AgentIPC) handleListTombstones(client *IPCClient, seq uint64) error {
+	header := responseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+	resp := i.discoverd.ListTombstones()
+	return client.Send(&header, resp)
+}
+
+func (i *AgentIPC) handleListConsumers(client *IPCClient, seq uint64) error {
+	header := responseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+	resp := i.discoverd.ListConsumers()
+	return client.Send(&header, resp)
+}
+
+// handleUpdateRouters replaces the router table, it is kept for the clients
+// predating the sync modes.
 func (i *AgentIPC) handleUpdateRouters(client *IPCClient, seq uint64) error {
 	var rs []api.Router
 	if err := client.dec.Decode(&rs); err != nil {
@@ -1091,6 +1191,134 @@ func (i *AgentIPC) handleUpdateRouters(client *IPCClient, seq uint64) error {
 	return client.Send(&resp, nil)
 }
 
+func (i *AgentIPC) handleSyncRouters(client *IPCClient, seq uint64) error {
+	var req syncRoutersRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return fmt.Errorf("decode failed: %v", err)
+	}
+
+	// Default to merge, replacing drops what the peer has not seen yet
+	if req.Mode == "" {
+		req.Mode = api.SyncMerge
+	}
+
+	resp := responseHeader{
+		Seq: seq,
+	}
+	if err := i.discoverd.SyncRouters(req.Routers, req.Mode); err != nil {
+		resp.Error = invalidSyncMode
+	}
+	return client.Send(&resp, nil)
+}
+
+func (i *AgentIPC) handleMaintenance(client *IPCClient, seq uint64) error {
+	var req maintenanceRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return fmt.Errorf("decode failed: %v", err)
+	}
+
+	header := responseHeader{
+		Seq: seq,
+	}
+	var resp maintenanceResponse
+	if req.Addr == "" {
+		resp.Addrs = i.discoverd.SetNodeMaintenance(req.Enable, req.Reason)
+	} else if i.discoverd.SetMaintenance(req.Addr, req.Enable, req.Reason) {
+		resp.Addrs = []string{req.Addr}
+	} else {
+		header.Error = appNotRegistered
+	}
+	return client.Send(&header, &resp)
+}
+
+func (i *AgentIPC) handleKVGet(client *IPCClient, seq uint64) error {
+	var req kvRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return fmt.Errorf("decode failed: %v", err)
+	}
+
+	header := responseHeader{
+		Seq: seq,
+	}
+	e, found := i.discoverd.KVGet(req.Key)
+	if !found {
+		header.Error = kvKeyNotFound
+	}
+	return client.Send(&header, &e)
+}
+
+func (i *AgentIPC) handleKVList(client *IPCClient, seq uint64) error {
+	var req kvListRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return fmt.Errorf("decode failed: %v", err)
+	}
+
+	header := responseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+	resp := i.discoverd.KVList(req.Prefix, req.Deleted)
+	return client.Send(&header, resp)
+}
+
+func (i *AgentIPC) handleKVPut(client *IPCClient, seq uint64) error {
+	var req kvRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return fmt.Errorf("decode failed: %v", err)
+	}
+
+	e, err := i.discoverd.KVPut(req.Key, req.Value)
+	header := responseHeader{
+		Seq:   seq,
+		Error: errToString(err),
+	}
+	return client.Send(&header, &e)
+}
+
+func (i *AgentIPC) handleKVDelete(client *IPCClient, seq uint64) error {
+	var req kvRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return fmt.Errorf("decode failed: %v", err)
+	}
+
+	header := responseHeader{
+		Seq: seq,
+	}
+	if _, found := i.discoverd.KVGet(req.Key); !found {
+		header.Error = kvKeyNotFound
+	} else if _, err := i.discoverd.KVDelete(req.Key); err != nil {
+		header.Error = errToString(err)
+	}
+	return client.Send(&header, nil)
+}
+
+func (i *AgentIPC) handleLookup(client *IPCClient, seq uint64) error {
+	var req lookupRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return fmt.Errorf("decode failed: %v", err)
+	}
+
+	header := responseHeader{
+		Seq: seq,
+	}
+	router, found, err := i.discoverd.Lookup(req.Service)
+	if err != nil {
+		header.Error = err.Error()
+	} else if !found {
+		header.Error = serviceNotFound
+	}
+	return client.Send(&header, &router)
+}
+
+func (i *AgentIPC) handleDeps(client *IPCClient, seq uint64) error {
+	header := responseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+	resp := i.discoverd.Dependencies()
+	return client.Send(&header, resp)
+}
+
 // handleGetCoordinate is used to get the cached coordinate for a node.
 func (i *AgentIPC) handleGetCoordinate(client *IPCClient, seq uint64) error {
 	var req coordinateRequest
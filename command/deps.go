@@ -0,0 +1,172 @@
+package command
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/mitchellh/cli"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DepsCommand is a Command implementation that prints the dependency graph
+// of the services of the cluster known to a running Blued agent.
+type DepsCommand struct {
+	Ui cli.Ui
+}
+
+func (c *DepsCommand) Help() string {
+	helpText := `
+Usage: blued deps [options] [service]
+
+  Prints the providers and consumers of every service of the cluster, as
+  gossiped with the registrations of the apps. With a service, only its
+  providers and consumers are printed.
+
+  In the DOT output, a service points to the services it consumes: an
+  app consuming a service is drawn as the services it provides, or as
+  its addr if it provides none.
+
+Options:
+  -format=json              Output format, 'json' or 'dot' (Graphviz).
+  -rpc-addr=127.0.0.1:7373  RPC address of the Blued agent.
+  -rpc-auth=""              RPC auth token of the Blued agent.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *DepsCommand) Run(args []string) int {
+	var format string
+	cmdFlags := flag.NewFlagSet("deps", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&format, "format", "json", "output format")
+	rpcAddr := RPCAddrFlag(cmdFlags)
+	rpcAuth := RPCAuthFlag(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	service := ""
+	switch args := cmdFlags.Args(); len(args) {
+	case 0:
+	case 1:
+		service = args[0]
+	default:
+		c.Ui.Error("At most one service can be specified.")
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+	if format != "json" && format != "dot" {
+		c.Ui.Error(fmt.Sprintf("Invalid output format \"%s\"", format))
+		return 1
+	}
+
+	client, err := RPCClient(*rpcAddr, *rpcAuth)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Blued agent: %s", err))
+		return 1
+	}
+	defer client.Close()
+
+	deps, err := client.Dependencies()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error listing dependencies: %s", err))
+		return 1
+	}
+
+	if format == "dot" {
+		c.Ui.Output(depsDOT(deps, service))
+		return 0
+	}
+
+	var data interface{} = deps
+	if service != "" {
+		found := false
+		for _, sd := range deps {
+			if sd.Service == service {
+				data, found = sd, true
+				break
+			}
+		}
+		if !found {
+			c.Ui.Error(fmt.Sprintf("Service %s is neither provided nor consumed", service))
+			return 1
+		}
+	}
+	output, err := formatOutput(data, format)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Encoding error: %s", err))
+		return 1
+	}
+	c.Ui.Output(string(output))
+	return 0
+}
+
+func (c *DepsCommand) Synopsis() string {
+	return "Print the dependency graph of the services"
+}
+
+// depsDOT renders the dependencies as a Graphviz digraph where services
+// point to the services they consume. If only is set, only the edges from
+// or to that service are kept.
+func depsDOT(deps []api.ServiceDeps, only string) string {
+	provided := make(map[string][]string)
+	for _, sd := range deps {
+		for _, addr := range sd.Providers {
+			provided[addr] = append(provided[addr], sd.Service)
+		}
+	}
+
+	services := make(map[string]bool)
+	apps := make(map[string]bool)
+	edges := make(map[string]bool)
+	for _, sd := range deps {
+		if only == "" || sd.Service == only {
+			services[sd.Service] = true
+		}
+		for _, addr := range sd.Consumers {
+			froms, isProvider := provided[addr]
+			if !isProvider {
+				froms = []string{addr}
+			}
+			for _, from := range froms {
+				if from == sd.Service || (only != "" && from != only && sd.Service != only) {
+					continue
+				}
+				edges[strconv.Quote(from)+" -> "+strconv.Quote(sd.Service)] = true
+				services[sd.Service] = true
+				if isProvider {
+					services[from] = true
+				} else {
+					apps[from] = true
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("digraph deps {\n")
+	for _, s := range sortedKeys(services) {
+		fmt.Fprintf(&buf, "  %s [shape=box];\n", strconv.Quote(s))
+	}
+	for _, a := range sortedKeys(apps) {
+		fmt.Fprintf(&buf, "  %s [shape=ellipse];\n", strconv.Quote(a))
+	}
+	for _, e := range sortedKeys(edges) {
+		fmt.Fprintf(&buf, "  %s;\n", e)
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
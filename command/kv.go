@@ -0,0 +1,140 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mitchellh/cli"
+	"strings"
+)
+
+// KVCommand is a Command implementation that reads and writes the
+// key/value store of a running Blued agent.
+type KVCommand struct {
+	Ui cli.Ui
+}
+
+func (c *KVCommand) Help() string {
+	helpText := `
+Usage: blued kv [options] <subcommand> [key] [value]
+
+  Reads and writes the key/value store replicated across the cluster.
+
+Subcommands:
+
+  get key           Prints the value of a key.
+  put key value     Sets the value of a key.
+  delete key        Deletes a key.
+  list [prefix]     Lists the entries whose key starts with the prefix.
+
+Options:
+
+  -format=text              Output format of list, 'json' or 'text'.
+  -rpc-addr=127.0.0.1:7373  RPC address of the Blued agent.
+  -rpc-auth=""              RPC auth token of the Blued agent.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVCommand) Run(args []string) int {
+	var format string
+	cmdFlags := flag.NewFlagSet("kv", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&format, "format", "text", "output format")
+	rpcAddr := RPCAddrFlag(cmdFlags)
+	rpcAuth := RPCAuthFlag(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) == 0 {
+		c.Ui.Error("A subcommand must be specified.")
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+	sub, args := args[0], args[1:]
+
+	nargs := map[string][]int{
+		"get":    {1, 1},
+		"put":    {2, 2},
+		"delete": {1, 1},
+		"list":   {0, 1},
+	}
+	n, exist := nargs[sub]
+	if !exist {
+		c.Ui.Error(fmt.Sprintf("Unknown subcommand: %s", sub))
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+	if len(args) < n[0] || len(args) > n[1] {
+		c.Ui.Error(fmt.Sprintf("Wrong number of arguments for %s.", sub))
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
+	client, err := RPCClient(*rpcAddr, *rpcAuth)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Blued agent: %s", err))
+		return 1
+	}
+	defer client.Close()
+
+	switch sub {
+	case "get":
+		e, err := client.KVGet(args[0])
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error getting key: %s", err))
+			return 1
+		}
+		if e == nil {
+			c.Ui.Error(fmt.Sprintf("Key not found: %s", args[0]))
+			return 1
+		}
+		c.Ui.Output(e.Value)
+
+	case "put":
+		if _, err := client.KVPut(args[0], args[1]); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error putting key: %s", err))
+			return 1
+		}
+		c.Ui.Output(fmt.Sprintf("Successfully put key %s", args[0]))
+
+	case "delete":
+		if err := client.KVDelete(args[0]); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error deleting key: %s", err))
+			return 1
+		}
+		c.Ui.Output(fmt.Sprintf("Successfully deleted key %s", args[0]))
+
+	case "list":
+		prefix := ""
+		if len(args) > 0 {
+			prefix = args[0]
+		}
+		es, err := client.KVList(prefix, false)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error listing keys: %s", err))
+			return 1
+		}
+		if format == "json" {
+			output, err := formatOutput(es, format)
+			if err != nil {
+				c.Ui.Error(fmt.Sprintf("Encoding error: %s", err))
+				return 1
+			}
+			c.Ui.Output(string(output))
+			return 0
+		}
+		for _, e := range es {
+			c.Ui.Output(fmt.Sprintf("%s=%s", e.Key, e.Value))
+		}
+	}
+	return 0
+}
+
+func (c *KVCommand) Synopsis() string {
+	return "Read and write the replicated key/value store"
+}
@@ -0,0 +1,98 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/mitchellh/cli"
+	"github.com/ryanuber/columnize"
+	"strings"
+)
+
+// LookupCommand is a Command implementation that asks the cluster for the
+// providers of a service through a running Blued agent.
+type LookupCommand struct {
+	Ui cli.Ui
+}
+
+// LookupContainer is the router of a service in the output formats.
+type LookupContainer struct {
+	api.Router
+}
+
+func (c LookupContainer) String() string {
+	var result []string
+	for _, na := range c.Addrs {
+		result = append(result, fmt.Sprintf("%s|%s|%s|%d",
+			na.Node, na.Addr, na.Status, na.Weight))
+	}
+	return columnize.SimpleFormat(result)
+}
+
+func (c *LookupCommand) Help() string {
+	helpText := `
+Usage: blued lookup [options] service
+
+  Asks the peers of the cluster for the providers of a service, merges
+  them into the router table of the Blued agent and prints the instances
+  of the service.
+
+  Agents look up the services missing from their router table on their
+  own, this forces a lookup.
+
+Options:
+  -format=text              Output format, 'json' or 'text'.
+  -rpc-addr=127.0.0.1:7373  RPC address of the Blued agent.
+  -rpc-auth=""              RPC auth token of the Blued agent.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *LookupCommand) Run(args []string) int {
+	var format string
+	cmdFlags := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&format, "format", "text", "output format")
+	rpcAddr := RPCAddrFlag(cmdFlags)
+	rpcAuth := RPCAuthFlag(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) != 1 {
+		c.Ui.Error("A single service must be specified.")
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
+	client, err := RPCClient(*rpcAddr, *rpcAuth)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Blued agent: %s", err))
+		return 1
+	}
+	defer client.Close()
+
+	router, err := client.Lookup(args[0])
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error looking up service: %s", err))
+		return 1
+	}
+	if router == nil {
+		c.Ui.Error(fmt.Sprintf("No provider of service %s found", args[0]))
+		return 1
+	}
+
+	output, err := formatOutput(LookupContainer{*router}, format)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Encoding error: %s", err))
+		return 1
+	}
+	c.Ui.Output(string(output))
+	return 0
+}
+
+func (c *LookupCommand) Synopsis() string {
+	return "Look up the providers of a service in the cluster"
+}
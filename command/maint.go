@@ -0,0 +1,98 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mitchellh/cli"
+	"strings"
+)
+
+// MaintCommand is a Command implementation that puts micro apps of a
+// running Blued agent into maintenance or takes them out of it.
+type MaintCommand struct {
+	Ui cli.Ui
+}
+
+func (c *MaintCommand) Help() string {
+	helpText := `
+Usage: blued maint [options] [addr]
+
+  Puts the app registered at addr with the Blued agent into maintenance,
+  or takes it out of maintenance. Without an addr every app registered
+  with the agent is affected.
+
+  Apps in maintenance are left out of the router tables, but keep their
+  registration and TTL so they can be re-enabled at any time.
+
+Options:
+  -enable                   Put the apps into maintenance.
+  -disable                  Take the apps out of maintenance.
+  -reason=""                Reason of the maintenance, shown with the apps.
+  -rpc-addr=127.0.0.1:7373  RPC address of the Blued agent.
+  -rpc-auth=""              RPC auth token of the Blued agent.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *MaintCommand) Run(args []string) int {
+	var enable, disable bool
+	var reason string
+	cmdFlags := flag.NewFlagSet("maint", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.BoolVar(&enable, "enable", false, "enable maintenance")
+	cmdFlags.BoolVar(&disable, "disable", false, "disable maintenance")
+	cmdFlags.StringVar(&reason, "reason", "", "maintenance reason")
+	rpcAddr := RPCAddrFlag(cmdFlags)
+	rpcAuth := RPCAuthFlag(cmdFlags)
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	if enable == disable {
+		c.Ui.Error("Exactly one of -enable or -disable must be specified.")
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
+	addr := ""
+	switch args := cmdFlags.Args(); len(args) {
+	case 0:
+	case 1:
+		addr = args[0]
+	default:
+		c.Ui.Error("At most one app addr can be specified.")
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
+	client, err := RPCClient(*rpcAddr, *rpcAuth)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Blued agent: %s", err))
+		return 1
+	}
+	defer client.Close()
+
+	addrs, err := client.Maintenance(addr, enable, reason)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error setting maintenance: %s", err))
+		return 1
+	}
+
+	for _, a := range addrs {
+		if enable {
+			c.Ui.Output(fmt.Sprintf("App %s is in maintenance", a))
+		} else {
+			c.Ui.Output(fmt.Sprintf("App %s is out of maintenance", a))
+		}
+	}
+	if len(addrs) == 0 {
+		c.Ui.Output("No app is registered")
+	}
+	return 0
+}
+
+func (c *MaintCommand) Synopsis() string {
+	return "Put micro apps into or out of maintenance"
+}
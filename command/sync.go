@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/bluefw/blued/client"
+	"github.com/bluefw/blued/discoverd/api"
 	"github.com/mitchellh/cli"
 	"strings"
 )
@@ -21,7 +22,13 @@ Usage: blued sync [options] node ...
   Tells a running Blued agent (with "blued agent") to sync router table
   by specifying at least one existing member name.
 
+  By default the router table of the member is merged into the local
+  one: instances are unioned per service and the most recent registration
+  of an instance wins. With -mode=replace the local router table is
+  dropped and replaced by the one of the member.
+
 Options:
+  -mode=merge               Sync mode, either merge or replace.
   -rpc-addr=127.0.0.1:7373  RPC address of the Blued agent.
   -rpc-auth=""              RPC auth token of the Blued agent.
 `
@@ -33,10 +40,18 @@ func (c *SyncCommand) Run(args []string) int {
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
 	rpcAddr := RPCAddrFlag(cmdFlags)
 	rpcAuth := RPCAuthFlag(cmdFlags)
+	mode := cmdFlags.String("mode", api.SyncMerge, "sync mode")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
+	if *mode != api.SyncMerge && *mode != api.SyncReplace {
+		c.Ui.Error(fmt.Sprintf("Invalid sync mode: %s", *mode))
+		c.Ui.Error("")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
 	nodes := cmdFlags.Args()
 	if len(nodes) == 0 {
 		c.Ui.Error("At least one member to sync must be specified.")
@@ -52,7 +67,7 @@ func (c *SyncCommand) Run(args []string) int {
 	}
 	defer client.Close()
 
-	syncAddr := c.SyncRouters(client, *rpcAuth, nodes)
+	syncAddr := c.SyncRouters(client, *rpcAuth, nodes, *mode)
 	if syncAddr != "" {
 		c.Ui.Output(fmt.Sprintf("Successfully sync router table from %s", syncAddr))
 	} else {
@@ -61,7 +76,7 @@ func (c *SyncCommand) Run(args []string) int {
 	return 0
 }
 
-func (c *SyncCommand) SyncRouters(cl *client.RPCClient, auth string, nodes []string) string {
+func (c *SyncCommand) SyncRouters(cl *client.RPCClient, auth string, nodes []string, mode string) string {
 	syncAddr := ""
 	respCh := make(chan client.NodeResponse, 64)
 	params := client.QueryParam{
@@ -83,7 +98,7 @@ OUTER:
 				break OUTER
 			}
 			if syncAddr == "" {
-				err := c.updateRouters(cl, string(r.Payload), auth)
+				err := c.updateRouters(cl, string(r.Payload), auth, mode)
 				if err == nil {
 					syncAddr = string(r.Payload)
 				}
@@ -94,7 +109,7 @@ OUTER:
 	return syncAddr
 }
 
-func (c *SyncCommand) updateRouters(cl *client.RPCClient, addr string, auth string) error {
+func (c *SyncCommand) updateRouters(cl *client.RPCClient, addr string, auth string, mode string) error {
 	tc, err := RPCClient(addr, auth)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error connecting to %s: %s", addr, err))
@@ -108,7 +123,7 @@ func (c *SyncCommand) updateRouters(cl *client.RPCClient, addr string, auth stri
 		return err
 	}
 
-	err = cl.UpdateRouters(rs)
+	err = cl.SyncRouters(rs, mode)
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error updating router table from %s: %s", addr, err))
 	}
@@ -71,6 +71,30 @@ func init() {
 			}, nil
 		},
 
+		"deps": func() (cli.Command, error) {
+			return &command.DepsCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"kv": func() (cli.Command, error) {
+			return &command.KVCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"lookup": func() (cli.Command, error) {
+			return &command.LookupCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"maint": func() (cli.Command, error) {
+			return &command.MaintCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"sync": func() (cli.Command, error) {
 			return &command.SyncCommand{
 				Ui: ui,
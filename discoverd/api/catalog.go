@@ -0,0 +1,32 @@
+package api
+
+// ServiceSummary counts the instances of a service in the router table.
+type ServiceSummary struct {
+	Service   string `json:"service"`
+	Instances int    `json:"instances"`
+	Passing   int    `json:"passing"`
+}
+
+// NodeService is an instance of a service provided by a node.
+type NodeService struct {
+	Service string `json:"service"`
+	NodeAddr
+}
+
+// ServiceDeps are the addrs of the apps providing and consuming a service.
+type ServiceDeps struct {
+	Service   string   `json:"service"`
+	Providers []string `json:"providers"`
+	Consumers []string `json:"consumers"`
+}
+
+// AppConsumers are the services consumed by an app of the cluster, as
+// gossiped with its registration at Version. Stale is set until a live
+// event or peer confirms the consumers loaded from a snapshot.
+type AppConsumers struct {
+	Node     string   `json:"node"`
+	Addr     string   `json:"addr"`
+	Services []string `json:"services"`
+	Version  uint64   `json:"version,omitempty"`
+	Stale    bool     `json:"stale,omitempty"`
+}
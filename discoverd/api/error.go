@@ -1,9 +1,24 @@
 package api
 
+// Codes of the errors returned by the REST API, so that clients don't
+// have to match messages.
+const (
+	ErrBadRequest      = "bad_request"
+	ErrInvalidApp      = "invalid_app"
+	ErrInvalidKey      = "invalid_key"
+	ErrAppNotFound     = "app_not_found"
+	ErrServiceNotFound = "service_not_found"
+	ErrKeyNotFound     = "key_not_found"
+	ErrConflict        = "conflict"
+	ErrTooLarge        = "too_large"
+	ErrInternal        = "internal"
+)
+
 type Error struct {
 	Error string `json:"error"`
+	Code  string `json:"code"`
 }
 
-func NewError(msg string) *Error {
-	return &Error{Error: msg}
+func NewError(code string, msg string) *Error {
+	return &Error{Error: msg, Code: code}
 }
@@ -0,0 +1,21 @@
+package api
+
+// KVEntry is a versioned key/value pair replicated across the cluster.
+// The entry with the highest LTime wins, ties are broken by the name of
+// the node that wrote it. Deleted entries are kept as tombstones for a
+// while so that older writes can't resurrect them.
+type KVEntry struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Node    string `json:"node"`
+	LTime   uint64 `json:"ltime"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Newer tells whether the entry wins over another version of its key.
+func (e *KVEntry) Newer(o *KVEntry) bool {
+	if e.LTime != o.LTime {
+		return e.LTime > o.LTime
+	}
+	return e.Node > o.Node
+}
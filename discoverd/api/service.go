@@ -1,29 +1,109 @@
 package api
 
+// ChecksumVersion is the version of the checksums of routers and router
+// tables. Version 1 was an additive MD5, version 2 is an order-independent
+// SHA-256. A client holding a checksum of another version must fetch its
+// router table again rather than compare checksums.
+const ChecksumVersion = 2
+
+// Modes of syncing the router table with the one of a peer. Merge unions
+// the instances of every service, replace drops the local router table.
+const (
+	SyncMerge   = "merge"
+	SyncReplace = "replace"
+)
+
+// MicroApp is an app registered on the agent. AppVersion, Tags and Weight
+// are the metadata of its instance, they are gossiped with the services
+// it provides so that consumers can route on them. Filters restricts the
+// instances of the consumed services, by service name.
 type MicroApp struct {
-	Addr      string   `json:"addr"`
-	Providers []string `json:"providers"`
-	Consumers []string `json:"consumers"`
+	Addr       string             `json:"addr"`
+	Providers  []string           `json:"providers"`
+	Consumers  []string           `json:"consumers"`
+	Filters    map[string]*Filter `json:"filters,omitempty"`
+	AppVersion string             `json:"appVersion,omitempty"`
+	Tags       map[string]string  `json:"tags,omitempty"`
+	Weight     int                `json:"weight,omitempty"`
+	Check      *Check             `json:"check,omitempty"`
+	Health     *Health            `json:"health,omitempty"`
+
+	// Maintenance takes the app out of the router tables, with the reason
+	// announced in place of its check output.
+	Maintenance       bool   `json:"maintenance,omitempty"`
+	MaintenanceReason string `json:"maintenanceReason,omitempty"`
+}
+
+// Filter selects the instances of a consumed service. An instance matches
+// if it has every tag of Tags and, if Version is set, a version within
+// the semver range of Version (e.g. ">=1.2.0, <2.0.0" or "^1.4").
+type Filter struct {
+	Tags    map[string]string `json:"tags,omitempty"`
+	Version string            `json:"version,omitempty"`
+}
+
+// Check is an optional health check definition of a micro app. The agent
+// probes the app itself and pulls its providers out of the router table
+// while the check is failing.
+type Check struct {
+	HTTP     string `json:"http,omitempty"`
+	TCP      string `json:"tcp,omitempty"`
+	Script   string `json:"script,omitempty"`
+	Interval string `json:"interval,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Failures int    `json:"failures,omitempty"`
+}
+
+// Health is the result of the last probe of a micro app's check.
+type Health struct {
+	Status string `json:"status"`
+	Output string `json:"output"`
 }
 
 type AppService struct {
-	Addr     string   `json:"addr"`
-	Services []string `json:"services"`
+	Addr       string            `json:"addr"`
+	Services   []string          `json:"services"`
+	Consumers  []string          `json:"consumers,omitempty"`
+	Status     string            `json:"status,omitempty"`
+	Output     string            `json:"output,omitempty"`
+	Version    uint64            `json:"version,omitempty"`
+	AppVersion string            `json:"appVersion,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Weight     int               `json:"weight,omitempty"`
 }
 
 type AppStatus struct {
-	IsLive   bool   `json:"isLive"`
-	RouterCS string `json:"routerCS"`
+	IsLive          bool   `json:"isLive"`
+	RouterCS        string `json:"routerCS"`
+	ChecksumVersion int    `json:"checksumVersion"`
 }
 
 type RouterTable struct {
-	Routers  []Router `json:"routers"`
-	Checksum string   `json:"checksum"`
+	Routers         []Router `json:"routers"`
+	Checksum        string   `json:"checksum"`
+	ChecksumVersion int      `json:"checksumVersion"`
 }
 
+// NodeAddr is an instance of a service. Status is the health of the
+// instance (passing, warning or critical), an empty status is passing.
+// Version orders the registrations of the same address, the highest one
+// wins when router tables are merged. AppVersion, Tags and Weight are the
+// metadata the app registered with. Stale instances were loaded from
+// a snapshot and are not confirmed by the cluster yet. RTT is the round
+// trip time to the node of the instance estimated by the agent answering
+// a fetch, in milliseconds, if known.
 type NodeAddr struct {
-	Node string `json:"node"`
-	Addr string `json:"addr"`
+	Node       string            `json:"node"`
+	Addr       string            `json:"addr"`
+	Status     string            `json:"status,omitempty"`
+	Output     string            `json:"output,omitempty"`
+	Version    uint64            `json:"version,omitempty"`
+	AppVersion string            `json:"appVersion,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Weight     int               `json:"weight,omitempty"`
+	Stale      bool              `json:"stale,omitempty"`
+	RTT        *float64          `json:"rtt,omitempty"`
 }
 
 type Router struct {
@@ -32,7 +112,42 @@ type Router struct {
 	Checksum []byte     `json:"checksum,omitempty"`
 }
 
+// RouterEvent is streamed to a micro app every time the router of a
+// service it consumes changes. Checksum is the new checksum of its
+// router table.
+type RouterEvent struct {
+	Service  string `json:"service"`
+	Router   Router `json:"router"`
+	Checksum string `json:"checksum"`
+}
+
+// InnerAppService is gossiped when an app registers. NodeAddr.Version is
+// the Lamport time of the registration, events older than what an agent
+// already holds for the address are ignored. Consumers are the services
+// the app consumes, they build the dependency graph of the cluster.
 type InnerAppService struct {
-	NodeAddr NodeAddr `json:"nodeaddr"`
-	Services []string `json:"services"`
+	NodeAddr  NodeAddr `json:"nodeaddr"`
+	Services  []string `json:"services"`
+	Consumers []string `json:"consumers,omitempty"`
+
+	// ConsumersOmitted is set when the consumers were left out to fit the
+	// registration in a serf event, the agents keep the ones they know.
+	ConsumersOmitted bool `json:"consumersOmitted,omitempty"`
+}
+
+// InnerAppUnregister is gossiped when an app unregisters. Version is the
+// Lamport time of the unregistration.
+type InnerAppUnregister struct {
+	Addr    string `json:"addr"`
+	Version uint64 `json:"version"`
+}
+
+// LookupResponse answers a lookup of a service with the instances running
+// on the node of the peer and the services they consume. Truncated is set
+// if some were left out to fit the response in a serf query response, the
+// anti-entropy brings them.
+type LookupResponse struct {
+	Router    Router         `json:"router"`
+	Consumers []AppConsumers `json:"consumers,omitempty"`
+	Truncated bool           `json:"truncated,omitempty"`
 }
@@ -0,0 +1,137 @@
+package check
+
+import (
+	"fmt"
+	"github.com/bluefw/blued/discoverd/api"
+	"log"
+	"math/rand"
+	"time"
+)
+
+const (
+	HealthPassing  = "passing"
+	HealthWarning  = "warning"
+	HealthCritical = "critical"
+
+	// HealthMaintenance is announced instead of the health of an app put
+	// into maintenance, its instances are left out of the router tables.
+	HealthMaintenance = "maintenance"
+
+	// MinInterval is the minimal interval between two probes of a check.
+	MinInterval = time.Second
+
+	// DefaultInterval is used when a check does not define an interval.
+	DefaultInterval = 10 * time.Second
+
+	// DefaultTimeout is used when a check does not define a timeout.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultFailures is the number of consecutive failed probes after
+	// which an app is taken out of the router table.
+	DefaultFailures = 3
+
+	// BufSize limits how much output we keep from a single probe.
+	BufSize = 4 * 1024
+)
+
+// Notify is implemented by whoever keeps the state of the checks. It is
+// called with the result of every probe.
+type Notify interface {
+	UpdateCheck(addr string, status string, output string)
+}
+
+// Check is a running health check of a single micro app.
+type Check interface {
+	Start()
+	Stop()
+}
+
+// NewCheck creates the check described by def for the app at addr.
+func NewCheck(addr string, def *api.Check, notify Notify, logger *log.Logger) (Check, error) {
+	interval, err := parseDuration(def.Interval, DefaultInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid check interval: %s", err)
+	}
+	if interval < MinInterval {
+		interval = MinInterval
+	}
+	timeout, err := parseDuration(def.Timeout, DefaultTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid check timeout: %s", err)
+	}
+
+	switch {
+	case def.HTTP != "":
+		url, err := resolveURL(addr, def.HTTP)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http check: %s", err)
+		}
+		return &CheckHTTP{
+			Addr:     addr,
+			URL:      url,
+			Interval: interval,
+			Timeout:  timeout,
+			Status:   def.Status,
+			Notify:   notify,
+			Logger:   logger,
+		}, nil
+	case def.TCP != "":
+		return &CheckTCP{
+			Addr:     addr,
+			TCP:      def.TCP,
+			Interval: interval,
+			Timeout:  timeout,
+			Notify:   notify,
+			Logger:   logger,
+		}, nil
+	case def.Script != "":
+		return &CheckScript{
+			Addr:     addr,
+			Script:   def.Script,
+			Interval: interval,
+			Timeout:  timeout,
+			Notify:   notify,
+			Logger:   logger,
+		}, nil
+	default:
+		return nil, fmt.Errorf("check type is not defined")
+	}
+}
+
+// Failures returns the number of consecutive failed probes tolerated
+// by the check definition.
+func Failures(def *api.Check) int {
+	if def.Failures > 0 {
+		return def.Failures
+	}
+	return DefaultFailures
+}
+
+func parseDuration(raw string, def time.Duration) (time.Duration, error) {
+	if raw == "" {
+		return def, nil
+	}
+	return time.ParseDuration(raw)
+}
+
+// run probes every interval until the stopCh is closed. The first probe
+// is randomly staggered so that checks registered together do not fire
+// at the same time.
+func run(interval time.Duration, stopCh chan struct{}, probe func()) {
+	next := time.After(randomStagger(interval))
+	for {
+		select {
+		case <-next:
+			probe()
+			next = time.After(interval)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// randomStagger returns an interval between 0 and the duration, it is
+// used to spread the first probe of the checks.
+func randomStagger(intv time.Duration) time.Duration {
+	return time.Duration(uint64(rand.Int63()) % uint64(intv))
+}
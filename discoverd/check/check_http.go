@@ -0,0 +1,87 @@
+package check
+
+import (
+	"fmt"
+	"github.com/armon/circbuf"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CheckHTTP periodically issues a GET against an HTTP endpoint of a micro
+// app. The probe passes when the endpoint answers with the expected status
+// code (200 by default) within the timeout.
+type CheckHTTP struct {
+	Addr     string
+	URL      string
+	Interval time.Duration
+	Timeout  time.Duration
+	Status   int
+	Notify   Notify
+	Logger   *log.Logger
+
+	client *http.Client
+	stop   bool
+	stopCh chan struct{}
+}
+
+// Start is used to start the check, runs until Stop() is called.
+func (c *CheckHTTP) Start() {
+	if c.Status == 0 {
+		c.Status = http.StatusOK
+	}
+	c.client = &http.Client{Timeout: c.Timeout}
+	c.stop = false
+	c.stopCh = make(chan struct{})
+	go run(c.Interval, c.stopCh, c.check)
+}
+
+// Stop is used to stop the check.
+func (c *CheckHTTP) Stop() {
+	if !c.stop {
+		c.stop = true
+		close(c.stopCh)
+	}
+}
+
+func (c *CheckHTTP) check() {
+	resp, err := c.client.Get(c.URL)
+	if err != nil {
+		c.Logger.Printf("[WARN] ds.check: http check of '%s' failed: %s", c.Addr, err)
+		c.Notify.UpdateCheck(c.Addr, HealthCritical, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	output, _ := circbuf.NewBuffer(BufSize)
+	if _, err := io.Copy(output, resp.Body); err != nil {
+		c.Logger.Printf("[WARN] ds.check: http check of '%s' error while reading body: %s", c.Addr, err)
+	}
+	result := fmt.Sprintf("HTTP GET %s: %s Output: %s", c.URL, resp.Status, output.String())
+
+	if resp.StatusCode != c.Status {
+		c.Logger.Printf("[WARN] ds.check: http check of '%s' is critical: %s", c.Addr, resp.Status)
+		c.Notify.UpdateCheck(c.Addr, HealthCritical, result)
+		return
+	}
+	c.Notify.UpdateCheck(c.Addr, HealthPassing, result)
+}
+
+// resolveURL resolves the check path against the address of the app.
+func resolveURL(addr string, path string) (string, error) {
+	base, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	u := base.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported scheme '%s'", u.Scheme)
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,105 @@
+package check
+
+import (
+	"fmt"
+	"github.com/armon/circbuf"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// CheckScript periodically runs a local script for a micro app. The exit
+// code of the script is mapped to the health of the app: 0 is passing,
+// 1 is warning and anything else is critical. The address of the app is
+// passed to the script in the BLUED_APP_ADDR environment variable.
+type CheckScript struct {
+	Addr     string
+	Script   string
+	Interval time.Duration
+	Timeout  time.Duration
+	Notify   Notify
+	Logger   *log.Logger
+
+	stop   bool
+	stopCh chan struct{}
+}
+
+// Start is used to start the check, runs until Stop() is called.
+func (c *CheckScript) Start() {
+	c.stop = false
+	c.stopCh = make(chan struct{})
+	go run(c.Interval, c.stopCh, c.check)
+}
+
+// Stop is used to stop the check.
+func (c *CheckScript) Stop() {
+	if !c.stop {
+		c.stop = true
+		close(c.stopCh)
+	}
+}
+
+func (c *CheckScript) check() {
+	output, _ := circbuf.NewBuffer(BufSize)
+
+	// Determine the shell invocation based on OS
+	var shell, flag string
+	if runtime.GOOS == "windows" {
+		shell = "cmd"
+		flag = "/C"
+	} else {
+		shell = "/bin/sh"
+		flag = "-c"
+	}
+
+	cmd := exec.Command(shell, flag, c.Script)
+	cmd.Env = append(os.Environ(), "BLUED_APP_ADDR="+c.Addr)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		c.Logger.Printf("[ERR] ds.check: failed to invoke script of '%s': %s", c.Addr, err)
+		c.Notify.UpdateCheck(c.Addr, HealthCritical, err.Error())
+		return
+	}
+
+	// Kill the script if it runs past the timeout
+	timer := time.AfterFunc(c.Timeout, func() {
+		c.Logger.Printf("[WARN] ds.check: script of '%s' timed out after %v", c.Addr, c.Timeout)
+		if err := killProcessGroup(cmd); err != nil {
+			c.Logger.Printf("[ERR] ds.check: failed to kill script of '%s': %s", c.Addr, err)
+		}
+	})
+	err := cmd.Wait()
+	timer.Stop()
+
+	// Warn if buffer is overritten
+	if output.TotalWritten() > output.Size() {
+		c.Logger.Printf("[WARN] ds.check: script of '%s' generated %d bytes of output, truncated to %d",
+			c.Addr, output.TotalWritten(), output.Size())
+	}
+	result := output.String()
+
+	if err == nil {
+		c.Notify.UpdateCheck(c.Addr, HealthPassing, result)
+		return
+	}
+
+	// Map the exit code to a health status
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			code := status.ExitStatus()
+			if code == 1 {
+				c.Notify.UpdateCheck(c.Addr, HealthWarning, result)
+				return
+			}
+			result = fmt.Sprintf("%s(exit status %d)", result, code)
+		}
+	}
+	c.Logger.Printf("[WARN] ds.check: script check of '%s' is critical: %s", c.Addr, err)
+	c.Notify.UpdateCheck(c.Addr, HealthCritical, result)
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package check
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup runs the script in its own process group, so the
+// processes it spawns can be killed with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the script and every process of its group, a
+// child left behind would keep the output open and block the wait.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
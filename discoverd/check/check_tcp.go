@@ -0,0 +1,48 @@
+package check
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// CheckTCP periodically opens a TCP connection to a micro app. The probe
+// passes when the connection is established within the timeout.
+type CheckTCP struct {
+	Addr     string
+	TCP      string
+	Interval time.Duration
+	Timeout  time.Duration
+	Notify   Notify
+	Logger   *log.Logger
+
+	stop   bool
+	stopCh chan struct{}
+}
+
+// Start is used to start the check, runs until Stop() is called.
+func (c *CheckTCP) Start() {
+	c.stop = false
+	c.stopCh = make(chan struct{})
+	go run(c.Interval, c.stopCh, c.check)
+}
+
+// Stop is used to stop the check.
+func (c *CheckTCP) Stop() {
+	if !c.stop {
+		c.stop = true
+		close(c.stopCh)
+	}
+}
+
+func (c *CheckTCP) check() {
+	conn, err := net.DialTimeout("tcp", c.TCP, c.Timeout)
+	if err != nil {
+		c.Logger.Printf("[WARN] ds.check: tcp check of '%s' failed: %s", c.Addr, err)
+		c.Notify.UpdateCheck(c.Addr, HealthCritical, err.Error())
+		return
+	}
+	conn.Close()
+	c.Notify.UpdateCheck(c.Addr, HealthPassing, fmt.Sprintf("TCP connect %s: Success", c.TCP))
+}
@@ -0,0 +1,184 @@
+package check
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockNotify struct {
+	sync.Mutex
+	status  map[string]string
+	updates map[string]int
+}
+
+func newMockNotify() *mockNotify {
+	return &mockNotify{
+		status:  make(map[string]string),
+		updates: make(map[string]int),
+	}
+}
+
+func (m *mockNotify) UpdateCheck(addr string, status string, output string) {
+	m.Lock()
+	defer m.Unlock()
+	m.status[addr] = status
+	m.updates[addr]++
+}
+
+func (m *mockNotify) state(addr string) (string, int) {
+	m.Lock()
+	defer m.Unlock()
+	return m.status[addr], m.updates[addr]
+}
+
+func expectHTTPStatus(t *testing.T, code int, def *api.Check, status string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(code)
+	}))
+	defer server.Close()
+
+	notify := newMockNotify()
+	addr := server.URL + "/rs"
+	ck, err := NewCheck(addr, def, notify, log.New(os.Stderr, "", log.LstdFlags))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ck.Start()
+	defer ck.Stop()
+
+	time.Sleep(1500 * time.Millisecond)
+
+	st, n := notify.state(addr)
+	if n == 0 {
+		t.Fatalf("check was not run")
+	}
+	if st != status {
+		t.Fatalf("bad status: %s, expect: %s", st, status)
+	}
+}
+
+func TestCheckHTTP_Passing(t *testing.T) {
+	expectHTTPStatus(t, http.StatusOK, &api.Check{HTTP: "/health", Interval: "1s"}, HealthPassing)
+}
+
+func TestCheckHTTP_Critical(t *testing.T) {
+	expectHTTPStatus(t, http.StatusServiceUnavailable, &api.Check{HTTP: "/health", Interval: "1s"}, HealthCritical)
+}
+
+func TestCheckHTTP_ExpectedStatus(t *testing.T) {
+	expectHTTPStatus(t, http.StatusNoContent, &api.Check{HTTP: "/health", Interval: "1s", Status: http.StatusNoContent}, HealthPassing)
+}
+
+func TestNewCheck_Invalid(t *testing.T) {
+	notify := newMockNotify()
+	if _, err := NewCheck("http://127.0.0.1:80/rs", &api.Check{}, notify, nil); err == nil {
+		t.Fatalf("expected error for a check without type")
+	}
+	if _, err := NewCheck("http://127.0.0.1:80/rs", &api.Check{HTTP: "/health", Interval: "foo"}, notify, nil); err == nil {
+		t.Fatalf("expected error for an invalid interval")
+	}
+	if _, err := NewCheck("127.0.0.1:80", &api.Check{HTTP: "/health"}, notify, nil); err == nil {
+		t.Fatalf("expected error for an address without scheme")
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	u, err := resolveURL("http://127.0.0.1:80/rs", "/health")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if u != "http://127.0.0.1:80/health" {
+		t.Fatalf("bad url: %s", u)
+	}
+}
+
+func TestCheckTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	notify := newMockNotify()
+	addr := "tcp://" + ln.Addr().String()
+	ck, err := NewCheck(addr, &api.Check{TCP: ln.Addr().String(), Interval: "1s"}, notify, log.New(os.Stderr, "", log.LstdFlags))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ck.Start()
+	time.Sleep(1500 * time.Millisecond)
+	ck.Stop()
+
+	if st, _ := notify.state(addr); st != HealthPassing {
+		t.Fatalf("bad status: %s", st)
+	}
+}
+
+func expectScriptStatus(t *testing.T, script string, status string) {
+	notify := newMockNotify()
+	addr := "tcp://127.0.0.1:80"
+	ck, err := NewCheck(addr, &api.Check{Script: script, Interval: "1s"}, notify, log.New(os.Stderr, "", log.LstdFlags))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ck.Start()
+	time.Sleep(1500 * time.Millisecond)
+	ck.Stop()
+
+	if st, _ := notify.state(addr); st != status {
+		t.Fatalf("bad status: %s, expect: %s", st, status)
+	}
+}
+
+func TestCheckScript(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("script checks are tested with /bin/sh")
+	}
+	expectScriptStatus(t, "exit 0", HealthPassing)
+	expectScriptStatus(t, "exit 1", HealthWarning)
+	expectScriptStatus(t, "exit 2", HealthCritical)
+	expectScriptStatus(t, `test "$BLUED_APP_ADDR" = "tcp://127.0.0.1:80"`, HealthPassing)
+}
+
+func TestCheckScript_Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("script checks are tested with /bin/sh")
+	}
+	notify := newMockNotify()
+	addr := "tcp://127.0.0.1:80"
+	// the background sleep keeps the output open unless the whole process
+	// group is killed
+	ck, err := NewCheck(addr, &api.Check{Script: "sleep 30 & sleep 30", Interval: "1s", Timeout: "500ms"},
+		notify, log.New(os.Stderr, "", log.LstdFlags))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ck.Start()
+	defer ck.Stop()
+
+	time.Sleep(2500 * time.Millisecond)
+	if st, n := notify.state(addr); n == 0 || st != HealthCritical {
+		t.Fatalf("bad status: %s after %d updates, expect: %s", st, n, HealthCritical)
+	}
+}
@@ -2,20 +2,66 @@ package cluster
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/bluefw/blued/discoverd/api"
 	"github.com/hashicorp/go-msgpack/codec"
 	"github.com/hashicorp/serf/serf"
 	"log"
+	"math"
+	"strings"
+	"time"
 )
 
 const (
 	RSCommand  = "rs"
 	URSCommand = "us"
+	KVCommand  = "kv"
+
+	// LookupCommand is the query asking the peers for their providers of
+	// a service.
+	LookupCommand = "lk"
+
+	// maxOutputSize limits the check output gossiped with a registration,
+	// serf user events are limited to a few hundred bytes.
+	maxOutputSize = 128
+
+	// queryResponseSizeLimit is the default size limit of serf query
+	// responses, which the agent keeps. queryResponseOverhead is the room
+	// taken by the header serf adds to a response, besides the node name.
+	queryResponseSizeLimit = 1024
+	queryResponseOverhead  = 64
 )
 
 type Cluster interface {
-	RegisterService(ss *api.AppService) error
-	UnregisterService(addr string) error
+	// RegisterService gossips a registration. Coalesced registrations sent
+	// within the coalesce period reach the peers as the last one only, a
+	// burst of registrations of several apps must not be coalesced.
+	RegisterService(ss *api.AppService, coalesce bool) error
+
+	// CheckService fails if the registration can't be gossiped, whatever
+	// the health announced with it.
+	CheckService(ss *api.AppService) error
+
+	// UnregisterService gossips an unregistration, coalesced as the
+	// registrations.
+	UnregisterService(addr string, version uint64, coalesce bool) error
+
+	// BroadcastKV gossips a write of the key/value store.
+	BroadcastKV(e *api.KVEntry) error
+
+	// RTT estimates the round trip time from the local node to a node
+	// with the network coordinates, false if it is unknown.
+	RTT(node string) (time.Duration, bool)
+
+	// LocalNode returns the name of the local node and NodeTags the value
+	// of a serf tag of every node of the cluster carrying it.
+	LocalNode() string
+	NodeTags(tag string) map[string]string
+
+	// LookupService asks the peers for their providers of a service, each
+	// responding peer answers with the instances running on its node and
+	// the services they consume.
+	LookupService(service string) ([]api.LookupResponse, error)
 }
 
 func EncodeMessage(msg interface{}) ([]byte, error) {
@@ -48,25 +94,160 @@ func (c *SerfCluster) GetSerf() *serf.Serf {
 	return c.serf
 }
 
-func (c *SerfCluster) RegisterService(ss *api.AppService) error {
-	ias := &api.InnerAppService{
+func (c *SerfCluster) RegisterService(ss *api.AppService, coalesce bool) error {
+	payload, err := c.encodeService(ss)
+	if err != nil {
+		return err
+	}
+	return c.serf.UserEvent(RSCommand, payload, coalesce)
+}
+
+// encodeService encodes the event gossiping a registration. The consumers
+// are left out, and flagged so, if the event would exceed the size limit.
+func (c *SerfCluster) encodeService(ss *api.AppService) ([]byte, error) {
+	ias := c.innerAppService(ss)
+	payload, err := EncodeMessage(ias)
+	if err != nil {
+		return nil, err
+	}
+	if len(RSCommand)+len(payload) > serf.UserEventSizeLimit && len(ias.Consumers) > 0 {
+		// the dependency graph is not worth failing the registration
+		c.logger.Printf("[WARN] ds.cluster: Too many consumers to gossip for app:%s", ss.Addr)
+		ias.Consumers = nil
+		ias.ConsumersOmitted = true
+		return EncodeMessage(ias)
+	}
+	return payload, nil
+}
+
+func (c *SerfCluster) CheckService(ss *api.AppService) error {
+	// the largest event the registration can make is with the longest
+	// check output and version, the consumers can be left out
+	worst := *ss
+	worst.Output = strings.Repeat(" ", maxOutputSize)
+	worst.Version = math.MaxUint64
+	ias := c.innerAppService(&worst)
+	ias.Consumers = nil
+	ias.ConsumersOmitted = true
+	payload, err := EncodeMessage(ias)
+	if err != nil {
+		return err
+	}
+	if size := len(RSCommand) + len(payload); size > serf.UserEventSizeLimit {
+		return fmt.Errorf("registration of %d bytes exceeds the %d bytes of a serf event, "+
+			"shorten the services, tags or version of the app", size, serf.UserEventSizeLimit)
+	}
+	return nil
+}
+
+// innerAppService returns the event gossiping a registration, with the
+// check output cut to maxOutputSize.
+func (c *SerfCluster) innerAppService(ss *api.AppService) *api.InnerAppService {
+	output := ss.Output
+	if len(output) > maxOutputSize {
+		output = output[:maxOutputSize]
+	}
+	return &api.InnerAppService{
 		NodeAddr: api.NodeAddr{
-			Node: c.node,
-			Addr: ss.Addr,
+			Node:       c.node,
+			Addr:       ss.Addr,
+			Status:     ss.Status,
+			Output:     output,
+			AppVersion: ss.AppVersion,
+			Tags:       ss.Tags,
+			Weight:     ss.Weight,
+			Version:    ss.Version,
 		},
-		Services: ss.Services,
+		Services:  ss.Services,
+		Consumers: ss.Consumers,
 	}
-	payload, err := EncodeMessage(ias)
+}
+
+func (c *SerfCluster) UnregisterService(addr string, version uint64, coalesce bool) error {
+	payload, err := EncodeMessage(&api.InnerAppUnregister{
+		Addr:    addr,
+		Version: version,
+	})
 	if err != nil {
 		return err
 	}
-	return c.serf.UserEvent(RSCommand, payload, true)
+	return c.serf.UserEvent(URSCommand, payload, coalesce)
 }
 
-func (c *SerfCluster) UnregisterService(addr string) error {
-	payload, err := EncodeMessage(addr)
+func (c *SerfCluster) BroadcastKV(e *api.KVEntry) error {
+	payload, err := EncodeMessage(e)
 	if err != nil {
 		return err
 	}
-	return c.serf.UserEvent(URSCommand, payload, true)
+	// not coalesced, serf would only deliver the last write of a burst
+	return c.serf.UserEvent(KVCommand, payload, false)
+}
+
+func (c *SerfCluster) RTT(node string) (time.Duration, bool) {
+	if node == c.node {
+		return 0, true
+	}
+	local, err := c.serf.GetCoordinate()
+	if err != nil {
+		return 0, false
+	}
+	coord, ok := c.serf.GetCachedCoordinate(node)
+	if !ok {
+		return 0, false
+	}
+	return local.DistanceTo(coord), true
+}
+
+func (c *SerfCluster) LocalNode() string {
+	return c.node
+}
+
+func (c *SerfCluster) NodeTags(tag string) map[string]string {
+	tags := make(map[string]string)
+	for _, m := range c.serf.Members() {
+		if v, exist := m.Tags[tag]; exist {
+			tags[m.Name] = v
+		}
+	}
+	return tags
+}
+
+func (c *SerfCluster) LookupService(service string) ([]api.LookupResponse, error) {
+	resp, err := c.serf.Query(LookupCommand, []byte(service), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var lrs []api.LookupResponse
+	for r := range resp.ResponseCh() {
+		var lr api.LookupResponse
+		if err := DecodeMessage(r.Payload, &lr); err != nil {
+			c.logger.Printf("[WARN] ds.cluster: Failed to decode lookup response from %s: %v", r.From, err)
+			continue
+		}
+		if lr.Truncated {
+			c.logger.Printf("[WARN] ds.cluster: Lookup response of service:%s from %s is truncated", service, r.From)
+		}
+		lrs = append(lrs, lr)
+	}
+	return lrs, nil
+}
+
+// EncodeLookupResponse encodes the answer of the node to a lookup. If it
+// doesn't fit in a serf query response, the consumers are left out first,
+// then the last instances, and the answer is flagged truncated.
+func EncodeLookupResponse(lr api.LookupResponse, node string) ([]byte, error) {
+	size := queryResponseSizeLimit - queryResponseOverhead - len(node)
+	for {
+		payload, err := EncodeMessage(&lr)
+		if err != nil || len(payload) <= size || len(lr.Router.Addrs) == 0 {
+			return payload, err
+		}
+		if len(lr.Consumers) > 0 {
+			lr.Consumers = nil
+		} else {
+			lr.Router.Addrs = lr.Router.Addrs[:len(lr.Router.Addrs)-1]
+		}
+		lr.Truncated = true
+	}
 }
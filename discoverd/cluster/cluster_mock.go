@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"github.com/bluefw/blued/discoverd/api"
+	"time"
 )
 
 type MockCluster struct {
@@ -11,10 +12,34 @@ func NewMockCluster() Cluster {
 	return &MockCluster{}
 }
 
-func (c MockCluster) RegisterService(ss *api.AppService) error {
+func (c MockCluster) RegisterService(ss *api.AppService, coalesce bool) error {
 	return nil
 }
 
-func (c MockCluster) UnregisterService(addr string) error {
+func (c MockCluster) CheckService(ss *api.AppService) error {
 	return nil
 }
+
+func (c MockCluster) UnregisterService(addr string, version uint64, coalesce bool) error {
+	return nil
+}
+
+func (c MockCluster) BroadcastKV(e *api.KVEntry) error {
+	return nil
+}
+
+func (c MockCluster) RTT(node string) (time.Duration, bool) {
+	return 0, false
+}
+
+func (c MockCluster) LocalNode() string {
+	return ""
+}
+
+func (c MockCluster) NodeTags(tag string) map[string]string {
+	return nil
+}
+
+func (c MockCluster) LookupService(service string) ([]api.LookupResponse, error) {
+	return nil, nil
+}
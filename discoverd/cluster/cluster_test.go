@@ -1,8 +1,11 @@
 package cluster
 
 import (
-	"blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/api"
 	"github.com/stretchr/testify/assert"
+	"log"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -23,3 +26,118 @@ func Test_encodeMessage(t *testing.T) {
 	assert.Contains(t, srvs, das.Services[1])
 
 }
+
+func Test_decodeVersionedRegistration(t *testing.T) {
+	// the registration gossiped by agents without app versions
+	type nodeAddr struct {
+		Node    string
+		Addr    string
+		Status  string
+		Output  string
+		Version uint64
+	}
+	old := struct {
+		NodeAddr nodeAddr
+		Services []string
+	}{nodeAddr{Node: "n1", Addr: "a1", Version: 42}, []string{"a.b"}}
+
+	raw, _ := EncodeMessage(&old)
+	var ias api.InnerAppService
+	if err := DecodeMessage(raw, &ias); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(t, uint64(42), ias.NodeAddr.Version)
+	assert.Equal(t, "", ias.NodeAddr.AppVersion)
+}
+
+func Test_CheckService(t *testing.T) {
+	c := &SerfCluster{node: "node1"}
+	ss := &api.AppService{
+		Addr:       "http://a.com:8080/rs",
+		Services:   []string{"a.b"},
+		Consumers:  []string{strings.Repeat("c", 400)},
+		AppVersion: "1.0.0",
+	}
+	if err := c.CheckService(ss); err != nil {
+		t.Fatalf("consumers failed the registration: %v", err)
+	}
+
+	ss.Tags = map[string]string{"desc": strings.Repeat("t", 300)}
+	if err := c.CheckService(ss); err == nil {
+		t.Fatal("registration larger than a serf event passed")
+	}
+}
+
+func Test_EncodeLookupResponse(t *testing.T) {
+	router := api.Router{Service: "a.b"}
+	for i := 0; i < 3; i++ {
+		router.Addrs = append(router.Addrs, api.NodeAddr{Node: "node1", Addr: "http://a.com:8080/rs"})
+	}
+	consumers := []api.AppConsumers{{Node: "node1", Addr: "http://a.com:8080/rs", Services: []string{"a.c"}}}
+	raw, err := EncodeLookupResponse(api.LookupResponse{Router: router, Consumers: consumers}, "node1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var lr api.LookupResponse
+	DecodeMessage(raw, &lr)
+	assert.False(t, lr.Truncated)
+	assert.Equal(t, 3, len(lr.Router.Addrs))
+	assert.Equal(t, 1, len(lr.Consumers))
+
+	// the consumers are left out first
+	consumers[0].Services = []string{strings.Repeat("c", 700)}
+	raw, err = EncodeLookupResponse(api.LookupResponse{Router: router, Consumers: consumers}, "node1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	lr = api.LookupResponse{}
+	DecodeMessage(raw, &lr)
+	assert.True(t, lr.Truncated)
+	assert.Equal(t, 3, len(lr.Router.Addrs))
+	assert.Equal(t, 0, len(lr.Consumers))
+
+	for i := 0; i < 50; i++ {
+		router.Addrs = append(router.Addrs, api.NodeAddr{Node: "node1", Addr: "http://a.com:8080/rs"})
+	}
+	raw, err = EncodeLookupResponse(api.LookupResponse{Router: router}, "node1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.True(t, len(raw) <= queryResponseSizeLimit-queryResponseOverhead-len("node1"))
+	lr = api.LookupResponse{}
+	DecodeMessage(raw, &lr)
+	assert.True(t, lr.Truncated)
+	assert.True(t, len(lr.Router.Addrs) > 0 && len(lr.Router.Addrs) < len(router.Addrs))
+}
+
+func Test_encodeServiceConsumersOmitted(t *testing.T) {
+	c := &SerfCluster{node: "node1", logger: log.New(os.Stderr, "", log.LstdFlags)}
+	ss := &api.AppService{
+		Addr:      "http://a.com:8080/rs",
+		Services:  []string{"a.b"},
+		Consumers: []string{"a.c"},
+	}
+	raw, err := c.encodeService(ss)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var ias api.InnerAppService
+	DecodeMessage(raw, &ias)
+	assert.Equal(t, []string{"a.c"}, ias.Consumers)
+	assert.False(t, ias.ConsumersOmitted)
+
+	// too many consumers for a serf event
+	ss.Consumers = []string{strings.Repeat("c", 500)}
+	if err := c.CheckService(ss); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	raw, err = c.encodeService(ss)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.True(t, len(RSCommand)+len(raw) <= 512)
+	ias = api.InnerAppService{}
+	DecodeMessage(raw, &ias)
+	assert.Equal(t, 0, len(ias.Consumers))
+	assert.True(t, ias.ConsumersOmitted)
+}
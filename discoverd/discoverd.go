@@ -1,13 +1,16 @@
 package discoverd
 
 import (
+	"fmt"
 	"github.com/bluefw/blued/discoverd/api"
 	"github.com/bluefw/blued/discoverd/cluster"
+	"github.com/bluefw/blued/discoverd/kv"
 	"github.com/bluefw/blued/discoverd/msd"
 
 	"github.com/hashicorp/serf/serf"
 	"io"
 	"log"
+	"net/http"
 	"time"
 )
 
@@ -19,32 +22,127 @@ type Context struct {
 	ShutdownCh chan struct{}
 }
 
+// Config is the configuration of discoverd.
+type Config struct {
+	RestAddr   string
+	ServiceTTL int
+
+	// TombstoneTTL is how long unregistered apps are remembered.
+	TombstoneTTL time.Duration
+
+	// LeaveTimeout is how long a leave waits for the unregistrations of
+	// the local apps to be broadcast.
+	LeaveTimeout time.Duration
+
+	// DataDir is where the local registrations are persisted.
+	DataDir string
+
+	// RouterSnapshotPath is the file the router table is snapshotted to.
+	RouterSnapshotPath string
+
+	// DNSAddr is the address the DNS server listens on, it is disabled
+	// if empty. DNSTTL is the TTL of its answers and DNSOrder their order,
+	// either random or rtt.
+	DNSAddr  string
+	DNSTTL   time.Duration
+	DNSOrder string
+
+	// ZoneTag is the serf tag holding the zone of a node, apps prefer the
+	// providers in their zone when set. ZoneMinInstances is the number of
+	// passing instances below which the other zones are used too.
+	ZoneTag          string
+	ZoneMinInstances int
+
+	// EnableScriptChecks allows the apps to register script checks.
+	EnableScriptChecks bool
+
+	// DisableServiceMetrics turns off the per service gauge of instances.
+	DisableServiceMetrics bool
+
+	// Metrics is served on /metrics of the REST server, if set.
+	Metrics http.Handler
+}
+
 type Discoverd struct {
-	repo       *msd.DiscoverdRepo
-	logger     *log.Logger
-	shutdownCh chan struct{}
+	repo         *msd.DiscoverdRepo
+	kv           *kv.KVStore
+	dns          *DNSServer
+	serf         *serf.Serf
+	leaveTimeout time.Duration
+	logger       *log.Logger
+	shutdownCh   chan struct{}
 }
 
-func Create(restAddr string, serviceTTL int, serf *serf.Serf, logOutput io.Writer) *Discoverd {
+func Create(conf *Config, serf *serf.Serf, logOutput io.Writer) *Discoverd {
 	logger := log.New(logOutput, "", log.LstdFlags)
-	ttl := time.Duration(serviceTTL) * time.Second
 	cluster := cluster.NewSerfCluster(serf, logger)
-	repo := msd.NewDiscoverdRepo(cluster, ttl, logger)
+	repo := msd.NewDiscoverdRepo(cluster, &msd.Config{
+		TTL:                   time.Duration(conf.ServiceTTL) * time.Second,
+		TombstoneTTL:          conf.TombstoneTTL,
+		DataDir:               conf.DataDir,
+		RouterSnapshotPath:    conf.RouterSnapshotPath,
+		ZoneTag:               conf.ZoneTag,
+		ZoneMinInstances:      conf.ZoneMinInstances,
+		EnableScriptChecks:    conf.EnableScriptChecks,
+		DisableServiceMetrics: conf.DisableServiceMetrics,
+	}, logger)
+	store := kv.NewKVStore(cluster, conf.TombstoneTTL, logger)
 	shutdownCh := make(chan struct{})
-	StartRestServer(restAddr, repo, logger, shutdownCh)
+	StartRestServer(conf.RestAddr, repo, store, conf.Metrics, logger, shutdownCh)
+
+	var dns *DNSServer
+	if conf.DNSAddr != "" {
+		var err error
+		dns, err = StartDNSServer(conf.DNSAddr, conf.DNSTTL, conf.DNSOrder, repo, logger)
+		if err != nil {
+			logger.Printf("[ERR] discoverd: Failed to start DNS server: %v", err)
+		}
+	}
 
 	return &Discoverd{
-		repo:       repo,
-		logger:     logger,
-		shutdownCh: shutdownCh,
+		repo:         repo,
+		kv:           store,
+		dns:          dns,
+		serf:         serf,
+		leaveTimeout: conf.LeaveTimeout,
+		logger:       logger,
+		shutdownCh:   shutdownCh,
 	}
 }
 
+// Leave unregisters the local apps from the cluster and waits for the
+// unregistrations to be broadcast, or the leave timeout to lapse. It must
+// be called before the serf leave.
+func (d *Discoverd) Leave() error {
+	addrs := d.repo.Leave()
+	d.logger.Printf("[INFO] discoverd: unregistered %d apps, waiting for broadcast ...", len(addrs))
+
+	timeout := time.After(d.leaveTimeout)
+	for d.serf.Stats()["event_queue"] != "0" {
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-timeout:
+			return fmt.Errorf("timeout broadcasting unregistrations of %d apps", len(addrs))
+		}
+	}
+	return nil
+}
+
 func (d *Discoverd) Shutdown() {
 	d.logger.Println("[INFO] discoverd: shutting down ...")
+	d.repo.Shutdown()
+	if d.dns != nil {
+		d.dns.Shutdown()
+	}
 	ShutdownRestServer()
 }
 
+// Restore registers again the apps persisted in the data dir before the
+// agent restarted.
+func (d *Discoverd) Restore() error {
+	return d.repo.Restore()
+}
+
 // ShutdownCh returns a channel that can be used to wait for
 // discoverd to shutdown.
 func (d *Discoverd) ShutdownCh() <-chan struct{} {
@@ -55,6 +153,18 @@ func (s *Discoverd) ListMicroApps() []api.MicroApp {
 	return s.repo.ListMicroApps()
 }
 
+// SetMaintenance puts a local app into maintenance or takes it out, it
+// returns false if the app is not registered.
+func (s *Discoverd) SetMaintenance(addr string, enable bool, reason string) bool {
+	return s.repo.SetMaintenance(addr, enable, reason)
+}
+
+// SetNodeMaintenance puts every local app into maintenance or takes them
+// out, it returns their addrs.
+func (s *Discoverd) SetNodeMaintenance(enable bool, reason string) []string {
+	return s.repo.SetNodeMaintenance(enable, reason)
+}
+
 func (s *Discoverd) ListRouters() []api.Router {
 	return s.repo.ListRouters()
 }
@@ -63,14 +173,106 @@ func (s *Discoverd) UpdateRouters(rs []api.Router) {
 	s.repo.UpdateRouters(rs)
 }
 
-func (s *Discoverd) AddRouter(name string, addr string, mss []string) {
-	s.repo.AddRouter(name, addr, mss)
+// SyncRouters syncs the router table with the routers of a peer in the
+// given mode, merge or replace.
+func (s *Discoverd) SyncRouters(rs []api.Router, mode string) error {
+	switch mode {
+	case api.SyncMerge:
+		s.repo.MergeRouters(rs)
+	case api.SyncReplace:
+		s.repo.UpdateRouters(rs)
+	default:
+		return fmt.Errorf("invalid sync mode: %s", mode)
+	}
+	return nil
 }
 
-func (s *Discoverd) RemoveRouter(addr string) {
-	s.repo.RemoveRouter(addr)
+func (s *Discoverd) MergeRouters(rs []api.Router) {
+	s.repo.MergeRouters(rs)
+}
+
+// Lookup asks the cluster for the providers of a service and merges them
+// into the router table, it returns the router of the service.
+func (s *Discoverd) Lookup(service string) (api.Router, bool, error) {
+	return s.repo.Lookup(service)
+}
+
+// LocalProviders returns the instances of a service running on the local
+// node with the services they consume.
+func (s *Discoverd) LocalProviders(service string) (api.LookupResponse, bool) {
+	return s.repo.LocalProviders(service)
+}
+
+// Dependencies returns the providers and consumers of every service of
+// the cluster.
+func (s *Discoverd) Dependencies() []api.ServiceDeps {
+	return s.repo.Dependencies()
+}
+
+// ListTombstones returns the unregistrations remembered by the agent.
+func (s *Discoverd) ListTombstones() []api.InnerAppUnregister {
+	return s.repo.ListTombstones()
+}
+
+// MergeTombstones applies the unregistrations of a peer.
+func (s *Discoverd) MergeTombstones(ts []api.InnerAppUnregister) {
+	s.repo.MergeTombstones(ts)
+}
+
+// ListConsumers returns the services consumed by every app of the cluster.
+func (s *Discoverd) ListConsumers() []api.AppConsumers {
+	return s.repo.ListConsumers()
+}
+
+// MergeConsumers merges the consumers known by a peer into the dependency
+// graph.
+func (s *Discoverd) MergeConsumers(cs []api.AppConsumers) {
+	s.repo.MergeConsumers(cs)
+}
+
+func (s *Discoverd) AddRouter(na api.NodeAddr, mss []string, consumers []string) {
+	s.repo.AddRouter(na, mss, consumers)
+}
+
+// AddRouterWithoutConsumers adds a registration gossiped without its
+// consumers, the ones known for the app are kept.
+func (s *Discoverd) AddRouterWithoutConsumers(na api.NodeAddr, mss []string) {
+	s.repo.AddRouterWithoutConsumers(na, mss)
+}
+
+func (s *Discoverd) RemoveRouter(addr string, version uint64) {
+	s.repo.RemoveRouter(addr, version)
 }
 
 func (s *Discoverd) RemoveRouterByHost(name string) {
 	s.repo.RemoveRouterByHost(name)
 }
+
+// KVGet returns the entry of a key of the key/value store.
+func (s *Discoverd) KVGet(key string) (api.KVEntry, bool) {
+	return s.kv.Get(key)
+}
+
+// KVList returns the entries whose key starts with the prefix, including
+// the tombstones of deleted keys if deleted is set.
+func (s *Discoverd) KVList(prefix string, deleted bool) []api.KVEntry {
+	return s.kv.List(prefix, deleted)
+}
+
+func (s *Discoverd) KVPut(key string, value string) (api.KVEntry, error) {
+	return s.kv.Put(key, value)
+}
+
+func (s *Discoverd) KVDelete(key string) (api.KVEntry, error) {
+	return s.kv.Delete(key)
+}
+
+// KVApply applies a write gossiped by another agent.
+func (s *Discoverd) KVApply(e api.KVEntry) {
+	s.kv.Apply(e)
+}
+
+// KVMerge merges the entries of a peer into the key/value store.
+func (s *Discoverd) KVMerge(es []api.KVEntry) {
+	s.kv.Merge(es)
+}
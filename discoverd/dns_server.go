@@ -0,0 +1,327 @@
+package discoverd
+
+import (
+	"encoding/hex"
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/msd"
+	"github.com/miekg/dns"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// dnsDomain is the domain the agent answers for. Services are looked
+	// up as <service>.service.blued. and the addresses of their instances
+	// as <ip>.addr.blued., IPv6 addresses as 32 hex digits.
+	dnsDomain        = "blued."
+	dnsServiceSuffix = ".service." + dnsDomain
+	dnsAddrSuffix    = ".addr." + dnsDomain
+
+	// dnsUDPSize is the size of UDP answers to requests without EDNS, the
+	// larger answers are truncated.
+	dnsUDPSize = 512
+
+	// Orders of the answers, random shuffles them and rtt sorts them by
+	// the estimated round trip time to the node of the instance.
+	DNSOrderRandom = "random"
+	DNSOrderRTT    = "rtt"
+)
+
+// DNSServer answers A, AAAA and SRV queries for the passing instances of
+// the services in the router table.
+type DNSServer struct {
+	repo   *msd.DiscoverdRepo
+	ttl    uint32
+	order  string
+	logger *log.Logger
+
+	servers []*dns.Server
+}
+
+// dnsTarget is an instance of a service as seen from DNS.
+type dnsTarget struct {
+	node   string
+	host   string
+	ip     net.IP
+	port   uint16
+	weight uint16
+}
+
+// StartDNSServer starts serving DNS over UDP and TCP on addr.
+func StartDNSServer(addr string, ttl time.Duration, order string, repo *msd.DiscoverdRepo,
+	logger *log.Logger) (*DNSServer, error) {
+	d := &DNSServer{
+		repo:   repo,
+		ttl:    uint32(ttl / time.Second),
+		order:  order,
+		logger: logger,
+	}
+
+	mux := dns.NewServeMux()
+	mux.HandleFunc(dnsDomain, d.handleQuery)
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	d.servers = []*dns.Server{
+		{PacketConn: pc, Handler: mux},
+		{Listener: l, Handler: mux},
+	}
+	for _, srv := range d.servers {
+		go func(srv *dns.Server) {
+			if err := srv.ActivateAndServe(); err != nil {
+				logger.Printf("[ERR] ds.dns: DNS server stopped: %v", err)
+			}
+		}(srv)
+	}
+	return d, nil
+}
+
+// Shutdown stops serving DNS.
+func (d *DNSServer) Shutdown() {
+	for _, srv := range d.servers {
+		srv.Shutdown()
+	}
+}
+
+func (d *DNSServer) handleQuery(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+
+	if len(req.Question) > 0 {
+		q := req.Question[0]
+		name := strings.ToLower(q.Name)
+		switch {
+		case strings.HasSuffix(name, dnsServiceSuffix):
+			d.answerService(m, q, strings.TrimSuffix(name, dnsServiceSuffix))
+		case strings.HasSuffix(name, dnsAddrSuffix):
+			d.answerAddr(m, q, strings.TrimSuffix(name, dnsAddrSuffix))
+		default:
+			m.Rcode = dns.RcodeNameError
+		}
+	}
+
+	// UDP answers are limited to the size advertised with EDNS, and to
+	// 512 bytes without it. Clients retry truncated answers over TCP.
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+		size := dnsUDPSize
+		if opt := req.IsEdns0(); opt != nil {
+			if s := int(opt.UDPSize()); s > size {
+				size = s
+			}
+			m.SetEdns0(uint16(size), false)
+		}
+		m.Truncate(size)
+	}
+
+	if err := w.WriteMsg(m); err != nil {
+		d.logger.Printf("[WARN] ds.dns: Failed to answer %v: %v", req.Question, err)
+	}
+}
+
+func (d *DNSServer) answerService(m *dns.Msg, q dns.Question, service string) {
+	router, found := d.repo.GetRouter(service, false)
+	if !found {
+		m.Rcode = dns.RcodeNameError
+		return
+	}
+
+	targets := d.sortTargets(dnsTargets(router.Addrs))
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		switch q.Qtype {
+		case dns.TypeA, dns.TypeAAAA, dns.TypeANY:
+			// Instances sharing an IP answer with a single record.
+			if t.ip == nil || seen[t.ip.String()] {
+				continue
+			}
+			if rr := d.ipRecord(q.Name, q.Qtype, t.ip); rr != nil {
+				seen[t.ip.String()] = true
+				m.Answer = append(m.Answer, rr)
+			}
+		case dns.TypeSRV:
+			target := dns.Fqdn(t.host)
+			if t.ip != nil {
+				target = addrLabel(t.ip) + dnsAddrSuffix
+				if !seen[target] {
+					seen[target] = true
+					m.Extra = append(m.Extra, d.ipRecord(target, dns.TypeANY, t.ip))
+				}
+			}
+			m.Answer = append(m.Answer, &dns.SRV{
+				Hdr:      dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: d.ttl},
+				Priority: 1,
+				Weight:   t.weight,
+				Port:     t.port,
+				Target:   target,
+			})
+		}
+	}
+}
+
+func (d *DNSServer) answerAddr(m *dns.Msg, q dns.Question, addr string) {
+	ip := parseAddrLabel(addr)
+	if ip == nil {
+		m.Rcode = dns.RcodeNameError
+		return
+	}
+	if rr := d.ipRecord(q.Name, q.Qtype, ip); rr != nil {
+		m.Answer = append(m.Answer, rr)
+	}
+}
+
+// ipRecord returns the A or AAAA record of ip, nil if the type of the
+// query doesn't match the family of ip. ANY matches both.
+func (d *DNSServer) ipRecord(name string, qtype uint16, ip net.IP) dns.RR {
+	if ip4 := ip.To4(); ip4 != nil {
+		if qtype != dns.TypeA && qtype != dns.TypeANY {
+			return nil
+		}
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: d.ttl},
+			A:   ip4,
+		}
+	}
+	if qtype != dns.TypeAAAA && qtype != dns.TypeANY {
+		return nil
+	}
+	return &dns.AAAA{
+		Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: d.ttl},
+		AAAA: ip.To16(),
+	}
+}
+
+// addrLabel returns the label of ip under the addr domain, the dotted IPv4
+// address or the 32 hex digits of the IPv6 address, as colons can't be
+// used in labels.
+func addrLabel(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	return hex.EncodeToString(ip.To16())
+}
+
+// parseAddrLabel is the reverse of addrLabel, nil if label is no address.
+func parseAddrLabel(label string) net.IP {
+	if ip := net.ParseIP(label); ip != nil && ip.To4() != nil {
+		return ip
+	}
+	if b, err := hex.DecodeString(label); err == nil && len(b) == net.IPv6len {
+		return net.IP(b)
+	}
+	return nil
+}
+
+// sortTargets orders the targets according to the configured order.
+func (d *DNSServer) sortTargets(ts []dnsTarget) []dnsTarget {
+	if d.order == DNSOrderRTT {
+		rtts := make(map[string]time.Duration, len(ts))
+		for _, t := range ts {
+			if rtt, ok := d.repo.RTT(t.node); ok {
+				rtts[t.node] = rtt
+			}
+		}
+		sort.Stable(&targetsByRTT{ts, rtts})
+		return ts
+	}
+
+	for i := range ts {
+		j := rand.Intn(i + 1)
+		ts[i], ts[j] = ts[j], ts[i]
+	}
+	return ts
+}
+
+// targetsByRTT sorts targets by round trip time, the ones without a known
+// round trip time last.
+type targetsByRTT struct {
+	ts   []dnsTarget
+	rtts map[string]time.Duration
+}
+
+func (t *targetsByRTT) Len() int      { return len(t.ts) }
+func (t *targetsByRTT) Swap(i, j int) { t.ts[i], t.ts[j] = t.ts[j], t.ts[i] }
+func (t *targetsByRTT) Less(i, j int) bool {
+	ri, iok := t.rtts[t.ts[i].node]
+	rj, jok := t.rtts[t.ts[j].node]
+	if iok != jok {
+		return iok
+	}
+	return ri < rj
+}
+
+// dnsTargets parses the host and port out of the addrs of the instances.
+// Addrs which can't be parsed are skipped.
+func dnsTargets(nas []api.NodeAddr) []dnsTarget {
+	ts := make([]dnsTarget, 0, len(nas))
+	for _, na := range nas {
+		host, port, ok := splitAddr(na.Addr)
+		if !ok {
+			continue
+		}
+		ts = append(ts, dnsTarget{
+			node:   na.Node,
+			host:   host,
+			ip:     net.ParseIP(host),
+			port:   port,
+			weight: srvWeight(na.Weight),
+		})
+	}
+	return ts
+}
+
+// srvWeight returns the SRV weight of an instance. Instances registered
+// without a weight weigh 1, as a zero weight is rarely picked by clients.
+func srvWeight(weight int) uint16 {
+	switch {
+	case weight <= 0:
+		return 1
+	case weight > math.MaxUint16:
+		return math.MaxUint16
+	}
+	return uint16(weight)
+}
+
+// splitAddr returns the host and port of an addr, either an URL such as
+// http://10.0.0.1:8080/rs or a bare host:port. The port of an URL defaults
+// to the one of its scheme.
+func splitAddr(addr string) (string, uint16, bool) {
+	hostport := addr
+	defPort := ""
+	if u, err := url.Parse(addr); err == nil && u.Host != "" {
+		hostport = u.Host
+		switch u.Scheme {
+		case "http":
+			defPort = "80"
+		case "https":
+			defPort = "443"
+		}
+	}
+
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		if defPort == "" {
+			return "", 0, false
+		}
+		host, port = strings.Trim(hostport, "[]"), defPort
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || host == "" {
+		return "", 0, false
+	}
+	return host, uint16(p), true
+}
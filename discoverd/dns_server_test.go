@@ -0,0 +1,136 @@
+package discoverd
+
+import (
+	"fmt"
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/miekg/dns"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func startDNSServer(t *testing.T) (*DNSServer, string, string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	repo := createRepo()
+	repo.AddRouter(api.NodeAddr{Node: "n1", Addr: "http://10.0.0.1:8080/rs", Weight: 5, Version: 1},
+		[]string{"a.b"}, nil)
+	repo.AddRouter(api.NodeAddr{Node: "n1", Addr: "http://10.0.0.1:8081/rs", Version: 1},
+		[]string{"a.b"}, nil)
+	repo.AddRouter(api.NodeAddr{Node: "n2", Addr: "http://[fd00::1]:8080/rs", Version: 1},
+		[]string{"a.b"}, nil)
+	for i := 0; i < 64; i++ {
+		repo.AddRouter(api.NodeAddr{Node: "n3", Addr: fmt.Sprintf("http://10.0.1.%d:8080/rs", i), Version: 1},
+			[]string{"a.big"}, nil)
+	}
+
+	d, err := StartDNSServer("127.0.0.1:0", 5*time.Second, DNSOrderRandom, repo, logger)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return d, d.servers[0].PacketConn.LocalAddr().String(), d.servers[1].Listener.Addr().String()
+}
+
+func dnsQuery(t *testing.T, addr string, name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	in, err := dns.Exchange(m, addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return in
+}
+
+func TestDNS_A(t *testing.T) {
+	d, addr, _ := startDNSServer(t)
+	defer d.Shutdown()
+
+	in := dnsQuery(t, addr, "a.b.service.blued.", dns.TypeA)
+	if len(in.Answer) != 1 {
+		t.Fatalf("instances sharing an IP are not deduplicated: %v", in.Answer)
+	}
+	if a, ok := in.Answer[0].(*dns.A); !ok || a.A.String() != "10.0.0.1" {
+		t.Fatalf("bad answer: %v", in.Answer[0])
+	}
+
+	in = dnsQuery(t, addr, "a.b.service.blued.", dns.TypeAAAA)
+	if len(in.Answer) != 1 {
+		t.Fatalf("bad answer: %v", in.Answer)
+	}
+	if aaaa, ok := in.Answer[0].(*dns.AAAA); !ok || aaaa.AAAA.String() != "fd00::1" {
+		t.Fatalf("bad answer: %v", in.Answer[0])
+	}
+
+	in = dnsQuery(t, addr, "missing.service.blued.", dns.TypeA)
+	if in.Rcode != dns.RcodeNameError {
+		t.Fatalf("bad rcode: %d", in.Rcode)
+	}
+}
+
+func TestDNS_SRV(t *testing.T) {
+	d, addr, _ := startDNSServer(t)
+	defer d.Shutdown()
+
+	in := dnsQuery(t, addr, "a.b.service.blued.", dns.TypeSRV)
+	if len(in.Answer) != 3 {
+		t.Fatalf("bad answer: %v", in.Answer)
+	}
+	weights := make(map[string]uint16)
+	for _, rr := range in.Answer {
+		srv := rr.(*dns.SRV)
+		weights[fmt.Sprintf("%s:%d", srv.Target, srv.Port)] = srv.Weight
+	}
+	expect := map[string]uint16{
+		"10.0.0.1.addr.blued.:8080":                         5,
+		"10.0.0.1.addr.blued.:8081":                         1,
+		"fd000000000000000000000000000001.addr.blued.:8080": 1,
+	}
+	for target, w := range expect {
+		if weights[target] != w {
+			t.Fatalf("bad weight of %s: %v", target, weights)
+		}
+	}
+	if len(in.Extra) != 2 {
+		t.Fatalf("bad extra: %v", in.Extra)
+	}
+
+	in = dnsQuery(t, addr, "fd000000000000000000000000000001.addr.blued.", dns.TypeAAAA)
+	if len(in.Answer) != 1 {
+		t.Fatalf("bad answer: %v", in.Answer)
+	}
+	if aaaa, ok := in.Answer[0].(*dns.AAAA); !ok || aaaa.AAAA.String() != "fd00::1" {
+		t.Fatalf("bad answer: %v", in.Answer[0])
+	}
+}
+
+func TestDNS_Truncate(t *testing.T) {
+	d, addr, tcpAddr := startDNSServer(t)
+	defer d.Shutdown()
+
+	in := dnsQuery(t, addr, "a.big.service.blued.", dns.TypeA)
+	if !in.Truncated || len(in.Answer) == 0 || len(in.Answer) == 64 {
+		t.Fatalf("UDP answer is not truncated: tc=%v answers=%d", in.Truncated, len(in.Answer))
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.big.service.blued.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	in, err := dns.Exchange(m, addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if in.Truncated || len(in.Answer) != 64 || in.IsEdns0() == nil {
+		t.Fatalf("EDNS answer is truncated: tc=%v answers=%d", in.Truncated, len(in.Answer))
+	}
+
+	c := &dns.Client{Net: "tcp"}
+	m = new(dns.Msg)
+	m.SetQuestion("a.big.service.blued.", dns.TypeA)
+	in, _, err = c.Exchange(m, tcpAddr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if in.Truncated || len(in.Answer) != 64 {
+		t.Fatalf("TCP answer is truncated: tc=%v answers=%d", in.Truncated, len(in.Answer))
+	}
+}
@@ -0,0 +1,83 @@
+package kv
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type KVResource struct {
+	store  *KVStore
+	logger *log.Logger
+}
+
+func NewKVResource(s *KVStore, l *log.Logger) *KVResource {
+	return &KVResource{
+		store:  s,
+		logger: l,
+	}
+}
+
+// Get returns the entry of the key, or with ?recurse=true the entries
+// whose key starts with it.
+func (kr *KVResource) Get(c *gin.Context) {
+	key := kvKey(c)
+	if c.Query("recurse") == "true" {
+		c.JSON(http.StatusOK, kr.store.List(key, false))
+		return
+	}
+
+	e, found := kr.store.Get(key)
+	if !found {
+		c.JSON(http.StatusNotFound, api.NewError(api.ErrKeyNotFound, "key not found"))
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+// Put sets the value of the key to the request body.
+func (kr *KVResource) Put(c *gin.Context) {
+	value, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.NewError(api.ErrBadRequest, "problem reading body"))
+		return
+	}
+
+	e, err := kr.store.Put(kvKey(c), string(value))
+	if err != nil {
+		kvError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, e)
+}
+
+func (kr *KVResource) Delete(c *gin.Context) {
+	key := kvKey(c)
+	if _, found := kr.store.Get(key); !found {
+		c.JSON(http.StatusNotFound, api.NewError(api.ErrKeyNotFound, "key not found"))
+		return
+	}
+	if _, err := kr.store.Delete(key); err != nil {
+		kvError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+func kvKey(c *gin.Context) string {
+	return strings.TrimPrefix(c.Params.ByName("key"), "/")
+}
+
+func kvError(c *gin.Context, err error) {
+	switch err {
+	case ErrEmptyKey:
+		c.JSON(http.StatusUnprocessableEntity, api.NewError(api.ErrInvalidKey, err.Error()))
+	case ErrTooLarge:
+		c.JSON(http.StatusRequestEntityTooLarge, api.NewError(api.ErrTooLarge, err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, api.NewError(api.ErrInternal, err.Error()))
+	}
+}
@@ -0,0 +1,196 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/cluster"
+	"github.com/bluefw/blued/discoverd/util/cache"
+	"github.com/hashicorp/serf/serf"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrEmptyKey is returned for writes of the empty key.
+	ErrEmptyKey = errors.New("key must not be empty")
+
+	// ErrTooLarge is returned for writes which can't be gossiped, as
+	// their key and value exceed the size limit of serf user events.
+	ErrTooLarge = fmt.Errorf("key and value exceed the %d bytes of a serf event", serf.UserEventSizeLimit)
+)
+
+// KVStore is a small key/value store replicated to every agent with serf
+// user events, and healed by the anti-entropy of the agent. Concurrent
+// writes of a key are resolved by last-writer-wins on their versions.
+type KVStore struct {
+	entries map[string]*api.KVEntry
+	lock    sync.RWMutex
+
+	// clock versions the writes, tombstones remember the deleted keys
+	// so older writes delivered late can't resurrect them.
+	clock      serf.LamportClock
+	tombstones *cache.Cache
+
+	cluster cluster.Cluster
+	logger  *log.Logger
+}
+
+func NewKVStore(cluster cluster.Cluster, tombstoneTTL time.Duration, l *log.Logger) *KVStore {
+	if l == nil {
+		l = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	s := &KVStore{
+		entries:    make(map[string]*api.KVEntry),
+		tombstones: cache.NewCache(tombstoneTTL, tombstoneTTL),
+		cluster:    cluster,
+		logger:     l,
+	}
+
+	// Start the clock at the wall time, so the versions of an agent keep
+	// increasing across restarts.
+	s.clock.Witness(serf.LamportTime(time.Now().UnixNano()))
+	return s
+}
+
+// Get returns the entry of a key.
+func (s *KVStore) Get(key string) (api.KVEntry, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	e, exist := s.entries[key]
+	if !exist {
+		return api.KVEntry{}, false
+	}
+	return *e, true
+}
+
+// List returns the entries whose key starts with the prefix, sorted by
+// key. Tombstones are included if deleted is set.
+func (s *KVStore) List(prefix string, deleted bool) []api.KVEntry {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	es := make(entriesByKey, 0, len(s.entries))
+	for k, e := range s.entries {
+		if strings.HasPrefix(k, prefix) {
+			es = append(es, *e)
+		}
+	}
+	if deleted {
+		for k, item := range s.tombstones.CopyItems() {
+			if strings.HasPrefix(k, prefix) && !item.Expired() {
+				es = append(es, *item.Object.(*api.KVEntry))
+			}
+		}
+	}
+	sort.Sort(es)
+	return es
+}
+
+// Put sets the value of a key and broadcasts it to the cluster.
+func (s *KVStore) Put(key string, value string) (api.KVEntry, error) {
+	return s.write(&api.KVEntry{
+		Key:   key,
+		Value: value,
+	})
+}
+
+// Delete deletes a key and broadcasts its deletion to the cluster.
+func (s *KVStore) Delete(key string) (api.KVEntry, error) {
+	return s.write(&api.KVEntry{
+		Key:     key,
+		Deleted: true,
+	})
+}
+
+func (s *KVStore) write(e *api.KVEntry) (api.KVEntry, error) {
+	if e.Key == "" {
+		return api.KVEntry{}, ErrEmptyKey
+	}
+	e.Node = s.cluster.LocalNode()
+	if err := checkSize(e); err != nil {
+		return api.KVEntry{}, err
+	}
+
+	e.LTime = uint64(s.clock.Increment())
+
+	// only apply writes the cluster is told about
+	if err := s.cluster.BroadcastKV(e); err != nil {
+		s.logger.Printf("[ERR] ds.kv: Failed to send kv event:%s", err)
+		return api.KVEntry{}, err
+	}
+	s.Apply(*e)
+	return *e, nil
+}
+
+// checkSize fails if the write can't be gossiped whatever its version.
+func checkSize(e *api.KVEntry) error {
+	worst := *e
+	worst.LTime = math.MaxUint64
+	payload, err := cluster.EncodeMessage(&worst)
+	if err != nil {
+		return err
+	}
+	if len(cluster.KVCommand)+len(payload) > serf.UserEventSizeLimit {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// Apply applies a write of another agent, unless a newer version of its
+// key is known. It returns whether the write was applied.
+func (s *KVStore) Apply(e api.KVEntry) bool {
+	s.clock.Witness(serf.LamportTime(e.LTime))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if cur, exist := s.current(e.Key); exist && !e.Newer(cur) {
+		return false
+	}
+	if e.Deleted {
+		delete(s.entries, e.Key)
+		e.Value = ""
+		s.tombstones.Set(e.Key, &e, cache.DefaultExpiration)
+	} else {
+		s.entries[e.Key] = &e
+		s.tombstones.Delete(e.Key)
+	}
+	return true
+}
+
+// Merge applies the entries of a peer, including its tombstones.
+func (s *KVStore) Merge(es []api.KVEntry) {
+	applied := 0
+	for _, e := range es {
+		if s.Apply(e) {
+			applied++
+		}
+	}
+	if applied > 0 {
+		s.logger.Printf("[INFO] ds.kv: Merged %d entries", applied)
+	}
+}
+
+// current returns the live entry or the tombstone of a key. It must be
+// called with the lock held.
+func (s *KVStore) current(key string) (*api.KVEntry, bool) {
+	if e, exist := s.entries[key]; exist {
+		return e, true
+	}
+	if t, found := s.tombstones.Get(key); found {
+		return t.(*api.KVEntry), true
+	}
+	return nil, false
+}
+
+type entriesByKey []api.KVEntry
+
+func (es entriesByKey) Len() int           { return len(es) }
+func (es entriesByKey) Swap(i, j int)      { es[i], es[j] = es[j], es[i] }
+func (es entriesByKey) Less(i, j int) bool { return es[i].Key < es[j].Key }
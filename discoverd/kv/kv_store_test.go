@@ -0,0 +1,84 @@
+package kv
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/cluster"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKVStore_PutDelete(t *testing.T) {
+	s := NewKVStore(cluster.NewMockCluster(), time.Hour, nil)
+
+	if _, err := s.Put("", "v"); err == nil {
+		t.Fatalf("expected error for empty key")
+	}
+
+	put, err := s.Put("app/conf", "v1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if e, found := s.Get("app/conf"); !found || e.Value != "v1" {
+		t.Fatalf("bad: %#v", e)
+	}
+	s.Put("other", "v2")
+	if es := s.List("app/", false); len(es) != 1 || es[0].Key != "app/conf" {
+		t.Fatalf("bad: %#v", es)
+	}
+
+	del, _ := s.Delete("app/conf")
+	if _, found := s.Get("app/conf"); found {
+		t.Fatalf("key should be deleted")
+	}
+	if es := s.List("app/", true); len(es) != 1 || !es[0].Deleted {
+		t.Fatalf("bad: %#v", es)
+	}
+
+	// the write deleted since can't come back
+	if s.Apply(put) {
+		t.Fatalf("older write applied")
+	}
+	if del.LTime <= put.LTime {
+		t.Fatalf("bad versions: %d %d", put.LTime, del.LTime)
+	}
+}
+
+func TestKVStore_TooLarge(t *testing.T) {
+	s := NewKVStore(cluster.NewMockCluster(), time.Hour, nil)
+
+	if _, err := s.Put("k", strings.Repeat("v", 400)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := s.Put("k", strings.Repeat("v", 600)); err != ErrTooLarge {
+		t.Fatalf("expected too large, got %v", err)
+	}
+	if _, err := s.Put(strings.Repeat("k", 600), "v"); err != ErrTooLarge {
+		t.Fatalf("expected too large, got %v", err)
+	}
+	if e, _ := s.Get("k"); len(e.Value) != 400 {
+		t.Fatalf("rejected write was applied: %d", len(e.Value))
+	}
+}
+
+func TestKVStore_Merge(t *testing.T) {
+	s := NewKVStore(cluster.NewMockCluster(), time.Hour, nil)
+	e, _ := s.Put("k", "local")
+
+	s.Merge([]api.KVEntry{
+		{Key: "k", Value: "older", Node: "b", LTime: e.LTime - 1},
+		{Key: "n", Value: "new", Node: "b", LTime: 1},
+	})
+	if e, _ := s.Get("k"); e.Value != "local" {
+		t.Fatalf("bad: %#v", e)
+	}
+	if e, _ := s.Get("n"); e.Value != "new" {
+		t.Fatalf("bad: %#v", e)
+	}
+
+	// ties are broken by node name
+	s.Apply(api.KVEntry{Key: "k", Value: "tie", Node: "z", LTime: e.LTime})
+	if e, _ := s.Get("k"); e.Value != "tie" {
+		t.Fatalf("bad: %#v", e)
+	}
+}
@@ -0,0 +1,87 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/check"
+	"sort"
+)
+
+// ListServices returns the services of the router table with the count
+// of their instances, sorted by service.
+func (s *DiscoverdRepo) ListServices() []api.ServiceSummary {
+	s.rtLock.RLock()
+	defer s.rtLock.RUnlock()
+
+	ss := make(summariesByService, 0, len(s.routers))
+	for service, r := range s.routers {
+		sum := api.ServiceSummary{
+			Service:   service,
+			Instances: len(r.Addrs),
+		}
+		for _, na := range r.Addrs {
+			if na.Status == "" || na.Status == check.HealthPassing {
+				sum.Passing++
+			}
+		}
+		ss = append(ss, sum)
+	}
+	sort.Sort(ss)
+	return ss
+}
+
+// ServiceInstances returns every instance of a service whatever its
+// health, sorted by addr.
+func (s *DiscoverdRepo) ServiceInstances(service string) ([]api.NodeAddr, bool) {
+	r, found := s.GetRouter(service, true)
+	if !found {
+		return nil, false
+	}
+	addrs := make(nodeAddrs, len(r.Addrs))
+	copy(addrs, r.Addrs)
+	sort.Sort(addrs)
+	return addrs, true
+}
+
+// NodeServices returns the instances provided by a node, sorted by
+// service and addr.
+func (s *DiscoverdRepo) NodeServices(node string) []api.NodeService {
+	s.rtLock.RLock()
+	defer s.rtLock.RUnlock()
+
+	ns := make(nodeServices, 0)
+	for service, r := range s.routers {
+		for _, na := range r.Addrs {
+			if na.Node == node {
+				ns = append(ns, api.NodeService{
+					Service:  service,
+					NodeAddr: na,
+				})
+			}
+		}
+	}
+	sort.Sort(ns)
+	return ns
+}
+
+type summariesByService []api.ServiceSummary
+
+func (s summariesByService) Len() int           { return len(s) }
+func (s summariesByService) Less(i, j int) bool { return s[i].Service < s[j].Service }
+func (s summariesByService) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type nodeServices []api.NodeService
+
+func (n nodeServices) Len() int      { return len(n) }
+func (n nodeServices) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n nodeServices) Less(i, j int) bool {
+	if n[i].Service != n[j].Service {
+		return n[i].Service < n[j].Service
+	}
+	return n[i].Addr < n[j].Addr
+}
+
+type appsByAddr []api.MicroApp
+
+func (a appsByAddr) Len() int           { return len(a) }
+func (a appsByAddr) Less(i, j int) bool { return a[i].Addr < a[j].Addr }
+func (a appsByAddr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
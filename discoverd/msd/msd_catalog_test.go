@@ -0,0 +1,96 @@
+package msd
+
+import (
+	"fmt"
+	"github.com/bluefw/blued/discoverd/api"
+	"testing"
+)
+
+func Test_ListServices(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a2", Version: 1}, []string{"c.d", "a.b"}, nil)
+	sr.AddRouter(api.NodeAddr{Node: "n2", Addr: "a1", Status: "critical", Version: 1}, []string{"a.b"}, nil)
+
+	ss := sr.ListServices()
+	if len(ss) != 2 || ss[0].Service != "a.b" || ss[1].Service != "c.d" {
+		t.Fatalf("services are not sorted: %v", ss)
+	}
+	if ss[0].Instances != 2 || ss[0].Passing != 1 {
+		t.Fatalf("bad summary: %v", ss[0])
+	}
+}
+
+func Test_ServiceInstances(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	for _, addr := range []string{"a3", "a1", "a2"} {
+		sr.AddRouter(api.NodeAddr{Node: "n1", Addr: addr, Status: "critical", Version: 1}, []string{"a.b"}, nil)
+	}
+
+	addrs, found := sr.ServiceInstances("a.b")
+	if !found || len(addrs) != 3 {
+		t.Fatalf("bad instances: %v", addrs)
+	}
+	for idx, addr := range []string{"a1", "a2", "a3"} {
+		if addrs[idx].Addr != addr {
+			t.Fatalf("instances are not sorted: %v", addrs)
+		}
+	}
+	if _, found := sr.ServiceInstances("x.y"); found {
+		t.Fatal("unknown service found")
+	}
+}
+
+func Test_NodeServices(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a2", Version: 1}, []string{"c.d", "a.b"}, nil)
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 1}, []string{"a.b"}, nil)
+	sr.AddRouter(api.NodeAddr{Node: "n2", Addr: "a3", Version: 1}, []string{"a.b"}, nil)
+
+	ns := sr.NodeServices("n1")
+	expected := []struct{ service, addr string }{{"a.b", "a1"}, {"a.b", "a2"}, {"c.d", "a2"}}
+	if len(ns) != len(expected) {
+		t.Fatalf("bad node services: %v", ns)
+	}
+	for idx, e := range expected {
+		if ns[idx].Service != e.service || ns[idx].Addr != e.addr {
+			t.Fatalf("node services are not sorted: %v", ns)
+		}
+	}
+}
+
+func Test_ListMicroApps(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	for _, addr := range []string{"a3", "a1", "a2"} {
+		sr.Register(&api.MicroApp{Addr: addr, Providers: []string{"a.b"}})
+	}
+
+	ms := sr.ListMicroApps()
+	for idx, addr := range []string{"a1", "a2", "a3"} {
+		if len(ms) != 3 || ms[idx].Addr != addr {
+			t.Fatalf("apps are not sorted: %v", ms)
+		}
+	}
+}
+
+func Test_ListMicroAppsConcurrentRegister(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			sr.Register(&api.MicroApp{Addr: fmt.Sprintf("a%d", i), Providers: []string{"a.b"}})
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			if ms := sr.ListMicroApps(); len(ms) != 200 {
+				t.Fatalf("bad apps: %d", len(ms))
+			}
+			return
+		default:
+			sr.ListMicroApps()
+		}
+	}
+}
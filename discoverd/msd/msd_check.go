@@ -0,0 +1,127 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/check"
+)
+
+// appCheck keeps the running health check of a local micro app together
+// with the result of its probes.
+type appCheck struct {
+	check     check.Check
+	status    string
+	output    string
+	failures  int
+	maxFails  int
+	announced string
+}
+
+func (s *DiscoverdRepo) startCheck(ma *api.MicroApp) error {
+	s.checkLock.Lock()
+	defer s.checkLock.Unlock()
+
+	s.stopCheck(ma.Addr)
+	if ma.Check == nil {
+		return nil
+	}
+
+	ck, err := check.NewCheck(ma.Addr, ma.Check, s, s.logger)
+	if err != nil {
+		return err
+	}
+	s.checks[ma.Addr] = &appCheck{
+		check:     ck,
+		status:    check.HealthPassing,
+		maxFails:  check.Failures(ma.Check),
+		announced: check.HealthPassing,
+	}
+	ck.Start()
+	return nil
+}
+
+// stopCheck must be called with the checkLock held.
+func (s *DiscoverdRepo) stopCheck(addr string) {
+	if ac, exist := s.checks[addr]; exist {
+		ac.check.Stop()
+		delete(s.checks, addr)
+	}
+}
+
+func (s *DiscoverdRepo) StopChecks() {
+	s.checkLock.Lock()
+	defer s.checkLock.Unlock()
+	for addr := range s.checks {
+		s.stopCheck(addr)
+	}
+}
+
+// UpdateCheck records the result of a probe. A change of the health of
+// the app is gossiped with its registration, a critical status is only
+// announced after maxFails consecutive critical probes.
+func (s *DiscoverdRepo) UpdateCheck(addr string, status string, output string) {
+	s.checkLock.Lock()
+	defer s.checkLock.Unlock()
+
+	ac, exist := s.checks[addr]
+	if !exist {
+		return
+	}
+	ac.status = status
+	ac.output = output
+
+	if status == check.HealthCritical {
+		ac.failures++
+		if ac.failures < ac.maxFails {
+			return
+		}
+	} else {
+		ac.failures = 0
+	}
+
+	if status != ac.announced {
+		s.logger.Printf("[INFO] ds.msd: Health of app:%s changed from %s to %s", addr, ac.announced, status)
+		ac.announced = status
+		s.announce(addr, status, output)
+	}
+}
+
+// health returns the result of the last probe of the app, or nil if the
+// app has no check.
+func (s *DiscoverdRepo) health(addr string) *api.Health {
+	s.checkLock.Lock()
+	defer s.checkLock.Unlock()
+
+	ac, exist := s.checks[addr]
+	if !exist {
+		return nil
+	}
+	return &api.Health{
+		Status: ac.status,
+		Output: ac.output,
+	}
+}
+
+// announced returns the health last announced for the app, passing if it
+// has no check.
+func (s *DiscoverdRepo) announced(addr string) (string, string) {
+	s.checkLock.Lock()
+	defer s.checkLock.Unlock()
+
+	ac, exist := s.checks[addr]
+	if !exist {
+		return check.HealthPassing, ""
+	}
+	return ac.announced, ac.output
+}
+
+func (s *DiscoverdRepo) announce(addr string, status string, output string) {
+	ma, found := s.apps.Get(addr)
+	if !found {
+		return
+	}
+	app := ma.(*api.MicroApp)
+	err := s.cluster.RegisterService(s.appService(app, status, output), true)
+	if err != nil {
+		s.logger.Printf("[ERR] msd.repo: Failed to send register event:%s", err)
+	}
+}
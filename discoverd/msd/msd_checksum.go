@@ -0,0 +1,85 @@
+package msd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/bluefw/blued/discoverd/api"
+	"io"
+	"sort"
+	"strconv"
+)
+
+type nodeAddrs []api.NodeAddr
+
+func (n nodeAddrs) Len() int           { return len(n) }
+func (n nodeAddrs) Less(i, j int) bool { return n[i].Addr < n[j].Addr }
+func (n nodeAddrs) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+
+type routersByService []api.Router
+
+func (r routersByService) Len() int           { return len(r) }
+func (r routersByService) Less(i, j int) bool { return r[i].Service < r[j].Service }
+func (r routersByService) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// calcChecksum digests the instances of a service. The instances are
+// sorted first, so the checksum doesn't depend on the order they were
+// registered in.
+func (s *DiscoverdRepo) calcChecksum(service string, ss []api.NodeAddr) []byte {
+	nas := make(nodeAddrs, len(ss))
+	copy(nas, ss)
+	sort.Sort(nas)
+
+	hasher := sha256.New()
+	writeField(hasher, service)
+	for _, na := range nas {
+		writeField(hasher, na.Node)
+		writeField(hasher, na.Addr)
+		writeField(hasher, na.Status)
+		writeField(hasher, na.AppVersion)
+		writeField(hasher, strconv.Itoa(na.Weight))
+
+		keys := make([]string, 0, len(na.Tags))
+		for k := range na.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeField(hasher, k)
+			writeField(hasher, na.Tags[k])
+		}
+		writeField(hasher, "")
+	}
+	return hasher.Sum(nil)
+}
+
+// calcRouterCheckSum returns the checksum of the router table of the app
+// at addr, as fetched without the instances that are not passing. It must
+// be called with the rtLock held.
+func (s *DiscoverdRepo) calcRouterCheckSum(addr string, zones map[string]string) string {
+	rt := s.calcRouterTable(addr, false, zones)
+	if rt == nil {
+		return ""
+	}
+	return rt.Checksum
+}
+
+// calcTableChecksum digests the checksums of the routers sorted by
+// service.
+func calcTableChecksum(routers []api.Router) string {
+	rs := make(routersByService, len(routers))
+	copy(rs, routers)
+	sort.Sort(rs)
+
+	hasher := sha256.New()
+	for _, r := range rs {
+		hasher.Write(r.Checksum)
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// writeField writes a NUL terminated field, so that the boundaries of
+// the fields are part of the digest.
+func writeField(w io.Writer, field string) {
+	w.Write([]byte(field))
+	w.Write([]byte{0})
+}
@@ -0,0 +1,67 @@
+package msd
+
+import (
+	"bytes"
+	"github.com/bluefw/blued/discoverd/api"
+	"testing"
+)
+
+func Test_ChecksumFields(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	base := api.NodeAddr{Node: "n1", Addr: "a1", Status: "passing", AppVersion: "1.0.0", Weight: 1,
+		Tags: map[string]string{"zone": "a"}}
+	sum := sr.calcChecksum("a.b", []api.NodeAddr{base})
+
+	changes := []func(na *api.NodeAddr){
+		func(na *api.NodeAddr) { na.Node = "n2" },
+		func(na *api.NodeAddr) { na.Addr = "a2" },
+		func(na *api.NodeAddr) { na.Status = "critical" },
+		func(na *api.NodeAddr) { na.AppVersion = "1.0.1" },
+		func(na *api.NodeAddr) { na.Weight = 2 },
+		func(na *api.NodeAddr) { na.Tags = map[string]string{"zone": "b"} },
+	}
+	for idx, change := range changes {
+		na := base
+		change(&na)
+		if bytes.Equal(sum, sr.calcChecksum("a.b", []api.NodeAddr{na})) {
+			t.Errorf("change %d does not change the checksum", idx)
+		}
+	}
+	if bytes.Equal(sum, sr.calcChecksum("a.c", []api.NodeAddr{base})) {
+		t.Error("service does not change the checksum")
+	}
+}
+
+func Test_ChecksumBoundaries(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	// the additive checksum could not tell these apart
+	s1 := sr.calcChecksum("a.b", []api.NodeAddr{{Node: "n1", Addr: "ab"}, {Node: "n1", Addr: "c"}})
+	s2 := sr.calcChecksum("a.b", []api.NodeAddr{{Node: "n1", Addr: "a"}, {Node: "n1", Addr: "bc"}})
+	if bytes.Equal(s1, s2) {
+		t.Error("field boundaries are not part of the checksum")
+	}
+}
+
+func Test_TableChecksum(t *testing.T) {
+	r1 := api.Router{Service: "a.b", Checksum: []byte{1}}
+	r2 := api.Router{Service: "a.c", Checksum: []byte{2}}
+	if calcTableChecksum([]api.Router{r1, r2}) != calcTableChecksum([]api.Router{r2, r1}) {
+		t.Error("table checksum depends on the order of the routers")
+	}
+	r2.Checksum = []byte{3}
+	if calcTableChecksum([]api.Router{r1, r2}) == calcTableChecksum([]api.Router{r1, {Service: "a.c", Checksum: []byte{2}}}) {
+		t.Error("table checksum does not change with a router")
+	}
+}
+
+func Test_RouterTableChecksumVersion(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	sr.Register(&api.MicroApp{Addr: "a1", Providers: []string{"a.b"}, Consumers: []string{"a.b"}})
+	rt := sr.GetRouterTable("a1", false)
+	if rt == nil || rt.ChecksumVersion != api.ChecksumVersion || rt.Checksum == "" {
+		t.Fatalf("bad router table: %v", rt)
+	}
+	if st := sr.Refresh("a1"); st.RouterCS != rt.Checksum || st.ChecksumVersion != api.ChecksumVersion {
+		t.Fatalf("refresh checksum %v does not match the router table %v", st, rt)
+	}
+}
@@ -0,0 +1,150 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"sort"
+)
+
+// appConsumers are the services consumed by an app of the cluster, as
+// gossiped with its registration at version. Stale flags the ones loaded
+// from the snapshot, until a live event or peer confirms them.
+type appConsumers struct {
+	node     string
+	services []string
+	version  uint64
+	stale    bool
+}
+
+func (ac appConsumers) appConsumers(addr string) api.AppConsumers {
+	return api.AppConsumers{
+		Node:     ac.node,
+		Addr:     addr,
+		Services: ac.services,
+		Version:  ac.version,
+		Stale:    ac.stale,
+	}
+}
+
+// setConsumers records the services consumed by the app registered with
+// na. A registration whose consumers were omitted keeps the known ones,
+// they are left out of the events exceeding the serf size limit. It must
+// be called with the rtLock held.
+func (s *DiscoverdRepo) setConsumers(na api.NodeAddr, services []string, omitted bool) {
+	if omitted {
+		ac, exist := s.consumers[na.Addr]
+		if !exist {
+			return
+		}
+		services = ac.services
+	}
+	if len(services) == 0 {
+		delete(s.consumers, na.Addr)
+		return
+	}
+	s.consumers[na.Addr] = appConsumers{node: na.Node, services: services, version: na.Version}
+}
+
+// ListConsumers returns the services consumed by every app of the cluster,
+// sorted by addr.
+func (s *DiscoverdRepo) ListConsumers() []api.AppConsumers {
+	s.rtLock.RLock()
+	defer s.rtLock.RUnlock()
+
+	cs := make(consumersByAddr, 0, len(s.consumers))
+	for addr, ac := range s.consumers {
+		cs = append(cs, ac.appConsumers(addr))
+	}
+	sort.Sort(cs)
+	return cs
+}
+
+// MergeConsumers merges the consumers known by a peer into the dependency
+// graph. As for the routers, the registration with the highest version
+// wins and the local one is kept on a tie, unless it is stale.
+func (s *DiscoverdRepo) MergeConsumers(cs []api.AppConsumers) {
+	s.rtLock.Lock()
+	defer s.rtLock.Unlock()
+
+	for _, c := range cs {
+		s.witness(c.Version)
+		if len(c.Services) == 0 || s.isStale(c.Addr, c.Version) {
+			continue
+		}
+		local, exist := s.consumers[c.Addr]
+		if exist && (c.Version < local.version ||
+			(c.Version == local.version && (c.Stale || !local.stale))) {
+			continue
+		}
+		s.consumers[c.Addr] = appConsumers{
+			node:     c.Node,
+			services: c.Services,
+			version:  c.Version,
+			stale:    c.Stale,
+		}
+	}
+}
+
+// Dependencies returns the providers and consumers of every service of
+// the cluster, sorted by service and addr.
+func (s *DiscoverdRepo) Dependencies() []api.ServiceDeps {
+	s.rtLock.RLock()
+	defer s.rtLock.RUnlock()
+
+	deps := make(map[string]*api.ServiceDeps)
+	get := func(service string) *api.ServiceDeps {
+		sd, exist := deps[service]
+		if !exist {
+			sd = &api.ServiceDeps{
+				Service:   service,
+				Providers: []string{},
+				Consumers: []string{},
+			}
+			deps[service] = sd
+		}
+		return sd
+	}
+	for service, r := range s.routers {
+		sd := get(service)
+		for _, na := range r.Addrs {
+			sd.Providers = append(sd.Providers, na.Addr)
+		}
+	}
+	for addr, ac := range s.consumers {
+		for _, service := range ac.services {
+			sd := get(service)
+			sd.Consumers = append(sd.Consumers, addr)
+		}
+	}
+
+	ds := make(depsByService, 0, len(deps))
+	for _, sd := range deps {
+		sort.Strings(sd.Providers)
+		sort.Strings(sd.Consumers)
+		ds = append(ds, *sd)
+	}
+	sort.Sort(ds)
+	return ds
+}
+
+// ServiceDependencies returns the providers and consumers of a service,
+// false if no app of the cluster provides or consumes it.
+func (s *DiscoverdRepo) ServiceDependencies(service string) (api.ServiceDeps, bool) {
+	for _, sd := range s.Dependencies() {
+		if sd.Service == service {
+			return sd, true
+		}
+	}
+	return api.ServiceDeps{}, false
+}
+
+type consumersByAddr []api.AppConsumers
+
+func (c consumersByAddr) Len() int           { return len(c) }
+func (c consumersByAddr) Less(i, j int) bool { return c[i].Addr < c[j].Addr }
+func (c consumersByAddr) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+type depsByService []api.ServiceDeps
+
+func (d depsByService) Len() int           { return len(d) }
+func (d depsByService) Less(i, j int) bool { return d[i].Service < d[j].Service }
+func (d depsByService) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
@@ -0,0 +1,91 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"testing"
+)
+
+func Test_Dependencies(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 1}, []string{"a.b"}, []string{"a.c"})
+	sr.AddRouter(api.NodeAddr{Node: "n2", Addr: "a2", Version: 1}, []string{"a.c"}, []string{"a.b", "a.d"})
+
+	ds := sr.Dependencies()
+	if len(ds) != 3 {
+		t.Fatalf("bad dependencies: %v", ds)
+	}
+	for _, sd := range ds {
+		switch sd.Service {
+		case "a.b":
+			if len(sd.Providers) != 1 || sd.Providers[0] != "a1" || len(sd.Consumers) != 1 || sd.Consumers[0] != "a2" {
+				t.Fatalf("bad dependencies: %v", sd)
+			}
+		case "a.c":
+			if len(sd.Providers) != 1 || sd.Providers[0] != "a2" || len(sd.Consumers) != 1 || sd.Consumers[0] != "a1" {
+				t.Fatalf("bad dependencies: %v", sd)
+			}
+		case "a.d":
+			if len(sd.Providers) != 0 || len(sd.Consumers) != 1 {
+				t.Fatalf("bad dependencies: %v", sd)
+			}
+		default:
+			t.Fatalf("unexpected service: %v", sd)
+		}
+	}
+}
+
+func Test_DependenciesKeepConsumers(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 1}, []string{"a.b"}, []string{"a.c"})
+
+	// an announcement gossiped without the consumers keeps them
+	sr.AddRouterWithoutConsumers(api.NodeAddr{Node: "n1", Addr: "a1", Status: "critical", Version: 2}, []string{"a.b"})
+	sd, found := sr.ServiceDependencies("a.c")
+	if !found || len(sd.Consumers) != 1 || sd.Consumers[0] != "a1" {
+		t.Fatalf("bad dependencies: %v", sd)
+	}
+
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 3}, []string{"a.b"}, []string{"a.d"})
+	if _, found := sr.ServiceDependencies("a.c"); found {
+		t.Fatal("replaced consumers kept")
+	}
+
+	// an app which stopped consuming clears them
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 4}, []string{"a.b"}, nil)
+	if _, found := sr.ServiceDependencies("a.d"); found {
+		t.Fatal("cleared consumers kept")
+	}
+
+	// an unregistration drops them
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 5}, []string{"a.b"}, []string{"a.c"})
+	sr.RemoveRouter("a1", 6)
+	if ds := sr.Dependencies(); len(ds) != 0 {
+		t.Fatalf("bad dependencies: %v", ds)
+	}
+}
+
+func Test_MergeConsumers(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 5}, []string{"a.b"}, []string{"a.c"})
+	sr.RemoveRouter("a3", 7)
+
+	sr.MergeConsumers([]api.AppConsumers{
+		{Node: "n1", Addr: "a1", Services: []string{"a.d"}, Version: 4},
+		{Node: "n2", Addr: "a2", Services: []string{"a.c"}, Version: 3},
+		{Node: "n3", Addr: "a3", Services: []string{"a.c"}, Version: 6},
+	})
+
+	cs := sr.ListConsumers()
+	if len(cs) != 2 || cs[0].Addr != "a1" || cs[1].Addr != "a2" {
+		t.Fatalf("bad consumers: %v", cs)
+	}
+	// the older registration of a1 and the unregistered a3 lose
+	if cs[0].Services[0] != "a.c" || cs[0].Version != 5 {
+		t.Fatalf("bad consumers: %v", cs[0])
+	}
+
+	sr.MergeConsumers([]api.AppConsumers{{Node: "n1", Addr: "a1", Services: []string{"a.d"}, Version: 8}})
+	if cs := sr.ListConsumers(); cs[0].Services[0] != "a.d" {
+		t.Fatalf("bad consumers: %v", cs[0])
+	}
+}
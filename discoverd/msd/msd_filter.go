@@ -0,0 +1,79 @@
+package msd
+
+import (
+	"fmt"
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/util/semver"
+)
+
+// validateFilters checks the version ranges of the filters of an app.
+func validateFilters(ma *api.MicroApp) error {
+	for ms, f := range ma.Filters {
+		if f == nil || f.Version == "" {
+			continue
+		}
+		if _, err := semver.ParseConstraint(f.Version); err != nil {
+			return fmt.Errorf("invalid filter of %s: %v", ms, err)
+		}
+	}
+	return nil
+}
+
+// consumedRouter returns the router of a service consumed by the app, with
+// the instances matching its filter of the service, in the zone of the
+// local node if enough of them are passing, nearest first. Only passing
+// instances are returned unless all is set. zones is the zone of every
+// node, as returned by nodeZones. It must be called with the rtLock held.
+func (s *DiscoverdRepo) consumedRouter(app *api.MicroApp, ms string, all bool,
+	zones map[string]string) (api.Router, bool) {
+	router, exist := s.routers[ms]
+	if !exist {
+		return router, false
+	}
+	if f := app.Filters[ms]; f != nil {
+		router = s.filterRouter(router, f)
+	}
+	router = s.zoneRouter(router, zones)
+	if !all {
+		router = passingRouter(router)
+	}
+	return s.sortByRTT(router), true
+}
+
+// filterRouter returns a copy of the router with the instances matching
+// the filter. Its checksum covers the matching instances only, so that
+// consumers don't see changes of the instances they filtered out.
+func (s *DiscoverdRepo) filterRouter(r api.Router, f *api.Filter) api.Router {
+	var cons *semver.Constraint
+	if f.Version != "" {
+		// filters are validated at registration
+		cons, _ = semver.ParseConstraint(f.Version)
+	}
+
+	addrs := make([]api.NodeAddr, 0, len(r.Addrs))
+	for _, na := range r.Addrs {
+		if matchFilter(na, f, cons) {
+			addrs = append(addrs, na)
+		}
+	}
+	return api.Router{
+		Service:  r.Service,
+		Addrs:    addrs,
+		Checksum: s.calcChecksum(r.Service, addrs),
+	}
+}
+
+func matchFilter(na api.NodeAddr, f *api.Filter, cons *semver.Constraint) bool {
+	for k, v := range f.Tags {
+		if tv, exist := na.Tags[k]; !exist || tv != v {
+			return false
+		}
+	}
+	if cons != nil {
+		ver, err := semver.Parse(na.AppVersion)
+		if err != nil || !cons.Check(ver) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"time"
+)
+
+const (
+	// lookupInterval limits how often a service missing from the router
+	// table is looked up in the cluster.
+	lookupInterval = 30 * time.Second
+
+	// lookupRate limits the lookups of missing services to one every
+	// lookupRate whatever the service, as each one queries every node of
+	// the cluster and any unknown name asked by a client triggers one.
+	lookupRate = time.Second
+)
+
+// Lookup asks the cluster for the providers of a service, merges the
+// answers into the router table and returns the router of the service,
+// false if no peer provides it.
+func (s *DiscoverdRepo) Lookup(service string) (api.Router, bool, error) {
+	s.lookupLock.Lock()
+	now := time.Now()
+	s.pruneLookups(now)
+	s.lookups[service] = now
+	s.lookupLock.Unlock()
+
+	lrs, err := s.cluster.LookupService(service)
+	if err != nil {
+		return api.Router{}, false, err
+	}
+	if len(lrs) > 0 {
+		rs := make([]api.Router, 0, len(lrs))
+		var cs []api.AppConsumers
+		for _, lr := range lrs {
+			rs = append(rs, lr.Router)
+			cs = append(cs, lr.Consumers...)
+		}
+		s.MergeRouters(rs)
+		s.MergeConsumers(cs)
+	}
+	router, found := s.findRouter(service)
+	return router, found, nil
+}
+
+// LocalProviders returns the instances of a service running on the local
+// node with the services they consume, which answer the lookups of the
+// peers. The check outputs are left out to keep the answer within the size
+// limit of serf queries.
+func (s *DiscoverdRepo) LocalProviders(service string) (api.LookupResponse, bool) {
+	router, exist := s.findRouter(service)
+	if !exist {
+		return api.LookupResponse{}, false
+	}
+
+	node := s.cluster.LocalNode()
+	var addrs []api.NodeAddr
+	for _, na := range router.Addrs {
+		if na.Node != node || na.Stale {
+			continue
+		}
+		na.Output = ""
+		addrs = append(addrs, na)
+	}
+	if len(addrs) == 0 {
+		return api.LookupResponse{}, false
+	}
+
+	s.rtLock.RLock()
+	var cs []api.AppConsumers
+	for _, na := range addrs {
+		if ac, exist := s.consumers[na.Addr]; exist {
+			cs = append(cs, ac.appConsumers(na.Addr))
+		}
+	}
+	s.rtLock.RUnlock()
+
+	return api.LookupResponse{
+		Router:    api.Router{Service: router.Service, Addrs: addrs},
+		Consumers: cs,
+	}, true
+}
+
+// lookupMissing looks a service missing from the router table up in the
+// background, at most once every lookupInterval per service and once every
+// lookupRate overall.
+func (s *DiscoverdRepo) lookupMissing(service string) {
+	select {
+	case <-s.stopCh:
+		return
+	default:
+	}
+
+	s.lookupLock.Lock()
+	now := time.Now()
+	last, exist := s.lookups[service]
+	if (exist && now.Sub(last) < lookupInterval) || now.Sub(s.lastLookup) < lookupRate {
+		s.lookupLock.Unlock()
+		return
+	}
+	s.pruneLookups(now)
+	s.lookups[service] = now
+	s.lastLookup = now
+	s.lookupLock.Unlock()
+
+	go func() {
+		s.logger.Printf("[INFO] ds.msd: Looking up missing service:%s", service)
+		if _, _, err := s.Lookup(service); err != nil {
+			s.logger.Printf("[WARN] ds.msd: Failed to look up service:%s: %v", service, err)
+		}
+	}()
+}
+
+// pruneLookups forgets the lookups older than lookupInterval, they no
+// longer hold back another one. It must be called with the lookupLock
+// held.
+func (s *DiscoverdRepo) pruneLookups(now time.Time) {
+	for service, last := range s.lookups {
+		if now.Sub(last) >= lookupInterval {
+			delete(s.lookups, service)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lookupCluster is a loopbackCluster whose peers answer the lookups with
+// their routers, it counts the lookups.
+type lookupCluster struct {
+	loopbackCluster
+	answers []api.LookupResponse
+
+	lock  sync.Mutex
+	calls []string
+}
+
+func (c *lookupCluster) LookupService(service string) ([]api.LookupResponse, error) {
+	c.lock.Lock()
+	c.calls = append(c.calls, service)
+	c.lock.Unlock()
+	return c.answers, nil
+}
+
+func (c *lookupCluster) lookups() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+func createLookupRepo(answers []api.LookupResponse) (*DiscoverdRepo, *lookupCluster) {
+	lc := &lookupCluster{
+		loopbackCluster: loopbackCluster{node: "node1"},
+		answers:         answers,
+	}
+	lc.repo = NewDiscoverdRepo(lc, &Config{TTL: time.Minute, TombstoneTTL: time.Minute}, nil)
+	return lc.repo, lc
+}
+
+func Test_LookupMerge(t *testing.T) {
+	sr, _ := createLookupRepo([]api.LookupResponse{
+		{
+			Router:    api.Router{Service: "a.b", Addrs: []api.NodeAddr{{Node: "n1", Addr: "a1", Version: 3}}},
+			Consumers: []api.AppConsumers{{Node: "n1", Addr: "a1", Services: []string{"a.c"}, Version: 3}},
+		},
+		{Router: api.Router{Service: "a.b", Addrs: []api.NodeAddr{
+			{Node: "n2", Addr: "a2", Version: 1},
+			{Node: "n2", Addr: "a3", Version: 5},
+		}}},
+	})
+	sr.AddRouter(api.NodeAddr{Node: "n2", Addr: "a3", Version: 4, Status: "critical"}, []string{"a.b"}, nil)
+
+	router, found, err := sr.Lookup("a.b")
+	if err != nil || !found {
+		t.Fatalf("lookup failed: %v %v", found, err)
+	}
+	if len(router.Addrs) != 3 {
+		t.Fatalf("bad router: %v", router)
+	}
+	for _, na := range router.Addrs {
+		if na.Addr == "a3" && (na.Version != 5 || na.Status != "") {
+			t.Fatalf("the newest registration lost: %v", na)
+		}
+	}
+
+	// the consumers of the providers come with them
+	sd, found := sr.ServiceDependencies("a.c")
+	if !found || len(sd.Consumers) != 1 || sd.Consumers[0] != "a1" {
+		t.Fatalf("bad dependencies: %v", sd)
+	}
+
+	if _, found, _ := sr.Lookup("a.c"); found {
+		t.Fatal("found a service no peer provides")
+	}
+}
+
+func Test_LookupMissingRate(t *testing.T) {
+	sr, lc := createLookupRepo(nil)
+
+	sr.lookupMissing("a.b")
+	sr.lookupMissing("a.b")
+	sr.lookupMissing("a.c")
+	time.Sleep(50 * time.Millisecond)
+	if calls := lc.lookups(); len(calls) != 1 || calls[0] != "a.b" {
+		t.Fatalf("bad lookups: %v", calls)
+	}
+
+	// past the global rate, only the service looked up recently waits
+	sr.lookupLock.Lock()
+	sr.lastLookup = time.Now().Add(-lookupRate)
+	sr.lookupLock.Unlock()
+	sr.lookupMissing("a.b")
+	sr.lookupMissing("a.c")
+	time.Sleep(50 * time.Millisecond)
+	if calls := lc.lookups(); len(calls) != 2 || calls[1] != "a.c" {
+		t.Fatalf("bad lookups: %v", calls)
+	}
+}
+
+func Test_LookupPrune(t *testing.T) {
+	sr, _ := createLookupRepo(nil)
+	sr.lookupLock.Lock()
+	sr.lookups["a.b"] = time.Now().Add(-lookupInterval)
+	sr.lookups["a.c"] = time.Now()
+	sr.lookupLock.Unlock()
+
+	sr.lookupMissing("a.d")
+
+	sr.lookupLock.Lock()
+	defer sr.lookupLock.Unlock()
+	if _, exist := sr.lookups["a.b"]; exist {
+		t.Fatal("old lookup not pruned")
+	}
+	if len(sr.lookups) != 2 {
+		t.Fatalf("bad lookups: %v", sr.lookups)
+	}
+}
+
+func Test_LocalProviders(t *testing.T) {
+	sr, _ := createLookupRepo(nil)
+	sr.AddRouter(api.NodeAddr{Node: "node1", Addr: "a1", Output: "ok", Version: 1}, []string{"a.b"}, []string{"a.c"})
+	sr.AddRouter(api.NodeAddr{Node: "n2", Addr: "a2", Version: 1}, []string{"a.b"}, []string{"a.d"})
+
+	lr, found := sr.LocalProviders("a.b")
+	if !found || len(lr.Router.Addrs) != 1 || lr.Router.Addrs[0].Addr != "a1" || lr.Router.Addrs[0].Output != "" {
+		t.Fatalf("bad router: %v", lr.Router)
+	}
+	if len(lr.Consumers) != 1 || lr.Consumers[0].Addr != "a1" || lr.Consumers[0].Services[0] != "a.c" {
+		t.Fatalf("bad consumers: %v", lr.Consumers)
+	}
+	if _, found := sr.LocalProviders("a.c"); found {
+		t.Fatal("found a service the node does not provide")
+	}
+}
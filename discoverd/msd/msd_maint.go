@@ -0,0 +1,59 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+)
+
+// SetMaintenance puts a local app into maintenance with a reason, or takes
+// it out of maintenance. The app keeps its registration and TTL, only its
+// instances are left out of the router tables. It returns false if the app
+// is not registered.
+func (s *DiscoverdRepo) SetMaintenance(addr string, enable bool, reason string) bool {
+	s.appLock.Lock()
+	defer s.appLock.Unlock()
+
+	item, found := s.apps.Get(addr)
+	if !found {
+		return false
+	}
+	ma := *item.(*api.MicroApp)
+	ma.Maintenance = enable
+	ma.MaintenanceReason = ""
+	if enable {
+		ma.MaintenanceReason = reason
+	}
+	if !s.apps.Replace(addr, &ma) {
+		return false
+	}
+	s.persist()
+
+	if enable {
+		s.logger.Printf("[INFO] ds.msd: Putting app:%s into maintenance: %s", addr, reason)
+	} else {
+		s.logger.Printf("[INFO] ds.msd: Taking app:%s out of maintenance", addr)
+	}
+	// not coalesced, the peers would only apply the last app of a node
+	// put into maintenance
+	status, output := s.announced(addr)
+	if err := s.cluster.RegisterService(s.appService(&ma, status, output), false); err != nil {
+		s.logger.Printf("[ERR] msd.repo: Failed to send register event:%s", err)
+	}
+	return true
+}
+
+// SetNodeMaintenance puts every local app into maintenance, or takes them
+// out of maintenance, and returns their addrs.
+func (s *DiscoverdRepo) SetNodeMaintenance(enable bool, reason string) []string {
+	var local []string
+	for _, ma := range s.ListMicroApps() {
+		local = append(local, ma.Addr)
+	}
+
+	addrs := make([]string, 0, len(local))
+	for _, addr := range local {
+		if s.SetMaintenance(addr, enable, reason) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,128 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/check"
+	"sync"
+	"testing"
+	"time"
+)
+
+// coalesceCluster is a loopbackCluster which records whether the last
+// event of every addr was sent coalesced.
+type coalesceCluster struct {
+	loopbackCluster
+	lock      sync.Mutex
+	coalesced map[string]bool
+}
+
+func (c *coalesceCluster) RegisterService(ss *api.AppService, coalesce bool) error {
+	c.lock.Lock()
+	c.coalesced[ss.Addr] = coalesce
+	c.lock.Unlock()
+	return c.loopbackCluster.RegisterService(ss, coalesce)
+}
+
+func (c *coalesceCluster) UnregisterService(addr string, version uint64, coalesce bool) error {
+	c.lock.Lock()
+	c.coalesced[addr] = coalesce
+	c.lock.Unlock()
+	return c.loopbackCluster.UnregisterService(addr, version, coalesce)
+}
+
+func createCoalesceRepo() (*DiscoverdRepo, *coalesceCluster) {
+	cc := &coalesceCluster{
+		loopbackCluster: loopbackCluster{node: "node1"},
+		coalesced:       make(map[string]bool),
+	}
+	cc.repo = NewDiscoverdRepo(cc, &Config{TTL: time.Minute, TombstoneTTL: time.Minute}, nil)
+	return cc.repo, cc
+}
+
+func Test_SetMaintenance(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	ma := &api.MicroApp{Addr: "a1", Providers: []string{"a.b"}}
+	sr.Register(ma)
+
+	if sr.SetMaintenance("a2", true, "upgrade") {
+		t.Fatal("unregistered app put into maintenance")
+	}
+	if !sr.SetMaintenance("a1", true, "upgrade") {
+		t.Fatal("app not put into maintenance")
+	}
+	r, _ := sr.GetRouter("a.b", true)
+	if len(r.Addrs) != 1 || r.Addrs[0].Status != check.HealthMaintenance || r.Addrs[0].Output != "upgrade" {
+		t.Fatalf("bad router: %v", r)
+	}
+	if r, _ := sr.GetRouter("a.b", false); len(r.Addrs) != 0 {
+		t.Fatalf("app in maintenance is routed to: %v", r)
+	}
+
+	// registering again keeps the app in maintenance
+	sr.Register(&api.MicroApp{Addr: "a1", Providers: []string{"a.b"}})
+	if r, _ := sr.GetRouter("a.b", true); r.Addrs[0].Status != check.HealthMaintenance {
+		t.Fatalf("registration took the app out of maintenance: %v", r)
+	}
+
+	sr.SetMaintenance("a1", false, "")
+	r, _ = sr.GetRouter("a.b", false)
+	if len(r.Addrs) != 1 || r.Addrs[0].Output != "" {
+		t.Fatalf("bad router: %v", r)
+	}
+}
+
+func Test_SetNodeMaintenance(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	sr.Register(&api.MicroApp{Addr: "a1", Providers: []string{"a.b"}})
+	sr.Register(&api.MicroApp{Addr: "a2", Providers: []string{"a.b"}})
+
+	if addrs := sr.SetNodeMaintenance(true, "drain"); len(addrs) != 2 {
+		t.Fatalf("bad addrs: %v", addrs)
+	}
+	if r, _ := sr.GetRouter("a.b", false); len(r.Addrs) != 0 {
+		t.Fatalf("apps in maintenance are routed to: %v", r)
+	}
+	sr.SetNodeMaintenance(false, "")
+	if r, _ := sr.GetRouter("a.b", false); len(r.Addrs) != 2 {
+		t.Fatalf("bad router: %v", r)
+	}
+}
+
+func Test_SetNodeMaintenanceNotCoalesced(t *testing.T) {
+	sr, cc := createCoalesceRepo()
+	sr.Register(&api.MicroApp{Addr: "a1", Providers: []string{"a.b"}})
+	sr.Register(&api.MicroApp{Addr: "a2", Providers: []string{"a.b"}})
+
+	// the peers would only apply the last registration of a coalesced burst
+	sr.SetNodeMaintenance(true, "drain")
+	for _, addr := range []string{"a1", "a2"} {
+		if cc.coalesced[addr] {
+			t.Fatalf("maintenance of %s coalesced", addr)
+		}
+	}
+}
+
+func Test_SetMaintenanceConcurrentRegister(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		sr := createDiscoverdRepo(nil)
+		sr.Register(&api.MicroApp{Addr: "a1", Providers: []string{"a.b"}})
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			sr.Register(&api.MicroApp{Addr: "a1", Providers: []string{"a.b", "a.c"}})
+		}()
+		go func() {
+			defer wg.Done()
+			sr.SetMaintenance("a1", true, "upgrade")
+		}()
+		wg.Wait()
+
+		v, _ := sr.apps.Get("a1")
+		ma := v.(*api.MicroApp)
+		if len(ma.Providers) != 2 || !ma.Maintenance {
+			t.Fatalf("concurrent update was lost: %+v", ma)
+		}
+	}
+}
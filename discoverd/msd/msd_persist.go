@@ -0,0 +1,83 @@
+package msd
+
+import (
+	"encoding/gob"
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/check"
+	"github.com/bluefw/blued/discoverd/util/cache"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// appsFile is the file the local registrations are persisted to, in
+	// the data dir of discoverd.
+	appsFile = "apps.snapshot"
+)
+
+func init() {
+	// the cache is persisted with gob, its objects are apps
+	gob.Register(&api.MicroApp{})
+}
+
+// persist snapshots the local registrations. Refreshes are not persisted,
+// the TTLs are re-armed on restore. Nothing is persisted without a data
+// dir.
+func (s *DiscoverdRepo) persist() {
+	if s.dataDir == "" {
+		return
+	}
+
+	s.persistLock.Lock()
+	defer s.persistLock.Unlock()
+
+	// write aside and rename, a crash must not leave a truncated snapshot
+	path := filepath.Join(s.dataDir, appsFile)
+	tmp := path + ".tmp"
+	if err := s.apps.SaveFile(tmp); err != nil {
+		s.logger.Printf("[ERR] msd.repo: Failed to persist apps:%s", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		s.logger.Printf("[ERR] msd.repo: Failed to persist apps:%s", err)
+	}
+}
+
+// Restore loads the registrations persisted before a restart, restarts
+// their checks and gossips them again. The snapshot is not updated by the
+// refreshes, so every app gets a full TTL to refresh again and the ones
+// which are gone expire after it.
+func (s *DiscoverdRepo) Restore() error {
+	if s.dataDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.dataDir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dataDir, appsFile)
+	if err := s.apps.LoadFile(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var mas []*api.MicroApp
+	for _, item := range s.apps.Items() {
+		mas = append(mas, item.Object.(*api.MicroApp))
+	}
+	for _, ma := range mas {
+		s.apps.Set(ma.Addr, ma, cache.DefaultExpiration)
+		s.logger.Printf("[INFO] ds.msd: Restoring app:%v", ma)
+		if err := s.startCheck(ma); err != nil {
+			s.logger.Printf("[ERR] msd.repo: Failed to start check of app:%s", err)
+		}
+		err := s.cluster.RegisterService(s.appService(ma, check.HealthPassing, ""), true)
+		if err != nil {
+			s.logger.Printf("[ERR] msd.repo: Failed to send register event:%s", err)
+		}
+	}
+	s.persist()
+	return nil
+}
@@ -0,0 +1,47 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_RestoreRearmsTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "msd")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &Config{TTL: 500 * time.Millisecond, TombstoneTTL: time.Minute, DataDir: dir}
+	sr := createDiscoverdRepo(conf)
+	sr.Register(&api.MicroApp{Addr: "a1", Providers: []string{"a.b"}})
+
+	// the app keeps refreshing past the TTL it was persisted with
+	for i := 0; i < 4; i++ {
+		time.Sleep(200 * time.Millisecond)
+		if st := sr.Refresh("a1"); !st.IsLive {
+			t.Fatal("app expired while refreshing")
+		}
+	}
+	sr.Shutdown()
+
+	sr = createDiscoverdRepo(conf)
+	if err := sr.Restore(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !sr.IsRegistered("a1") {
+		t.Fatal("refreshed app is not restored")
+	}
+	if _, exist := sr.GetRouter("a.b", true); !exist {
+		t.Fatal("restored app is not gossiped")
+	}
+
+	// without refreshes it expires after a full TTL
+	time.Sleep(time.Second)
+	if sr.IsRegistered("a1") {
+		t.Fatal("restored app did not expire")
+	}
+}
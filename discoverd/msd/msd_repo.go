@@ -1,78 +1,330 @@
 package msd
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/armon/go-metrics"
 	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/check"
 	"github.com/bluefw/blued/discoverd/cluster"
 	"github.com/bluefw/blued/discoverd/util/cache"
+	"github.com/hashicorp/serf/serf"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
+// ErrConflict is returned when registering an app that another agent of
+// the cluster announces, both agents would gossip and check it.
+var ErrConflict = errors.New("app is registered with another agent")
+
+// Config is the configuration of a DiscoverdRepo.
+type Config struct {
+	// TTL is how long a local app stays registered without refreshing.
+	TTL time.Duration
+
+	// TombstoneTTL is how long an unregistered addr is remembered.
+	TombstoneTTL time.Duration
+
+	// DataDir is where the local registrations are persisted, they are
+	// not persisted if empty.
+	DataDir string
+
+	// RouterSnapshotPath is the file the router table is snapshotted to,
+	// it is not snapshotted if empty.
+	RouterSnapshotPath string
+
+	// ZoneTag is the serf tag holding the zone of a node. If set, apps are
+	// routed to the providers in their zone unless it has fewer than
+	// ZoneMinInstances passing instances of a service.
+	ZoneTag          string
+	ZoneMinInstances int
+
+	// EnableScriptChecks allows the apps to register checks running a
+	// script on the node, registrations with one are rejected otherwise.
+	EnableScriptChecks bool
+
+	// DisableServiceMetrics turns off the gauge of the instances of every
+	// service, labelled by service, for clusters with many services.
+	DisableServiceMetrics bool
+}
+
 type DiscoverdRepo struct {
 	apps    *cache.Cache
 	ttl     time.Duration
 	routers map[string]api.Router
 	rtLock  sync.RWMutex
 
+	// consumers holds the services consumed by every app of the cluster,
+	// by addr, it is guarded by the rtLock.
+	consumers map[string]appConsumers
+
+	// changeCh is closed and replaced every time the router table
+	// changes, it wakes up the blocking fetches of router tables.
+	changeCh chan struct{}
+	stopCh   chan struct{}
+
+	// watchers are streaming the router changes of local apps.
+	watchers map[*RouterWatcher]struct{}
+
+	checks    map[string]*appCheck
+	checkLock sync.Mutex
+
+	// appLock serializes the updates of the local apps which read their
+	// registration first, such as registering again or maintenance.
+	appLock sync.Mutex
+
+	// clock versions the registrations, tombstones remember the version
+	// of the removed addresses so older events can't resurrect them.
+	clock      serf.LamportClock
+	tombstones *cache.Cache
+
+	dataDir      string
+	snapshotPath string
+	persistLock  sync.Mutex
+
+	zoneTag          string
+	zoneMinInstances int
+
+	enableScriptChecks    bool
+	disableServiceMetrics bool
+
+	// lookups remembers when the services missing from the router table
+	// were last looked up in the cluster, lastLookup the last of them.
+	lookups    map[string]time.Time
+	lastLookup time.Time
+	lookupLock sync.Mutex
+
 	cluster cluster.Cluster
 	logger  *log.Logger
 }
 
-func NewDiscoverdRepo(cluster cluster.Cluster, ttl time.Duration, l *log.Logger) *DiscoverdRepo {
+func NewDiscoverdRepo(cluster cluster.Cluster, conf *Config, l *log.Logger) *DiscoverdRepo {
 	if l == nil {
 		l = log.New(os.Stderr, "", log.LstdFlags)
 	}
 	dr := &DiscoverdRepo{
-		apps:    cache.NewCache(ttl, ttl),
-		ttl:     ttl,
+		apps:    cache.NewCache(conf.TTL, conf.TTL),
+		ttl:     conf.TTL,
 		routers: make(map[string]api.Router),
+		checks:  make(map[string]*appCheck),
 		cluster: cluster,
 		logger:  l,
+
+		consumers: make(map[string]appConsumers),
+
+		changeCh: make(chan struct{}),
+		stopCh:   make(chan struct{}),
+		watchers: make(map[*RouterWatcher]struct{}),
+		lookups:  make(map[string]time.Time),
+
+		tombstones:   cache.NewCache(conf.TombstoneTTL, conf.TombstoneTTL),
+		dataDir:      conf.DataDir,
+		snapshotPath: conf.RouterSnapshotPath,
+
+		zoneTag:          conf.ZoneTag,
+		zoneMinInstances: conf.ZoneMinInstances,
+
+		enableScriptChecks:    conf.EnableScriptChecks,
+		disableServiceMetrics: conf.DisableServiceMetrics,
 	}
+	if dr.zoneMinInstances < 1 {
+		dr.zoneMinInstances = 1
+	}
+
+	// Start the clock at the wall time, so the versions of an agent keep
+	// increasing across restarts.
+	dr.clock.Witness(serf.LamportTime(time.Now().UnixNano()))
 
 	dr.apps.RegExpiredHandler(func(dm map[string]interface{}) {
 		dr.OnAppExpired(dm)
 	})
+
+	if dr.snapshotPath != "" {
+		if err := dr.loadRouters(); err != nil {
+			dr.logger.Printf("[ERR] msd.repo: Failed to load router snapshot:%s", err)
+		}
+		go dr.snapshotRouters()
+	}
 	return dr
 }
 
 func (s *DiscoverdRepo) OnAppExpired(dm map[string]interface{}) {
 	s.logger.Printf("[INFO] msd: Expired app:%v", dm)
+	metrics.IncrCounter([]string{"discoverd", "expire"}, float32(len(dm)))
+	s.checkLock.Lock()
+	for k := range dm {
+		s.stopCheck(k)
+	}
+	s.checkLock.Unlock()
+
 	s.rtLock.Lock()
 	for k, _ := range dm {
-		err := s.cluster.UnregisterService(k)
+		err := s.cluster.UnregisterService(k, s.nextVersion(), true)
 		if err != nil {
 			s.logger.Printf("[ERR] msd.repo: Failed to send register event:%s", err)
 		}
 	}
 	s.rtLock.Unlock()
+	s.persist()
 }
 
-func (s *DiscoverdRepo) Register(ma *api.MicroApp) {
+func (s *DiscoverdRepo) Register(ma *api.MicroApp) error {
 	s.logger.Printf("[INFO] ds.msd: Registering app:%v", ma)
+	if ma.Addr == "" {
+		return fmt.Errorf("addr must not be empty")
+	}
+	if ma.Weight < 0 {
+		return fmt.Errorf("invalid weight %d, it must not be negative", ma.Weight)
+	}
+	if err := validateFilters(ma); err != nil {
+		return err
+	}
+	if ma.Check != nil && ma.Check.Script != "" && !s.enableScriptChecks {
+		return fmt.Errorf("script checks are disabled on this agent")
+	}
+	if err := s.cluster.CheckService(s.appService(ma, check.HealthMaintenance, "")); err != nil {
+		return err
+	}
+	if node, found := s.remoteOwner(ma.Addr); found {
+		s.logger.Printf("[WARN] ds.msd: App:%s is registered with node:%s", ma.Addr, node)
+		return ErrConflict
+	}
+	if err := s.startCheck(ma); err != nil {
+		s.logger.Printf("[ERR] msd.repo: Failed to start check of app:%s", err)
+		return err
+	}
+	s.appLock.Lock()
+	defer s.appLock.Unlock()
+
+	// an app registering again stays in maintenance
+	if old, found := s.apps.Get(ma.Addr); found && !ma.Maintenance {
+		ma.Maintenance = old.(*api.MicroApp).Maintenance
+		ma.MaintenanceReason = old.(*api.MicroApp).MaintenanceReason
+	}
 	s.apps.Set(ma.Addr, ma, cache.DefaultExpiration)
+	s.persist()
+	metrics.IncrCounter([]string{"discoverd", "register"}, 1)
 
-	err := s.cluster.RegisterService(&api.AppService{
-		Addr:     ma.Addr,
-		Services: ma.Providers,
-	})
+	err := s.cluster.RegisterService(s.appService(ma, check.HealthPassing, ""), true)
 	if err != nil {
 		s.logger.Printf("[ERR] msd.repo: Failed to send register event:%s", err)
 	}
+	return nil
+}
+
+// remoteOwner returns the node of another agent announcing the addr, the
+// entries of the snapshot left aside as their agent may be gone.
+func (s *DiscoverdRepo) remoteOwner(addr string) (string, bool) {
+	node := s.cluster.LocalNode()
+	s.rtLock.RLock()
+	defer s.rtLock.RUnlock()
+	for _, router := range s.routers {
+		for _, na := range router.Addrs {
+			if na.Addr == addr && na.Node != node && !na.Stale {
+				return na.Node, true
+			}
+		}
+	}
+	return "", false
+}
+
+// appService returns a new registration of the services provided by the
+// app, with its metadata and health. Apps in maintenance are announced as
+// such whatever their health.
+func (s *DiscoverdRepo) appService(ma *api.MicroApp, status string, output string) *api.AppService {
+	if ma.Maintenance {
+		status, output = check.HealthMaintenance, ma.MaintenanceReason
+	}
+	return &api.AppService{
+		Addr:       ma.Addr,
+		Services:   ma.Providers,
+		Consumers:  ma.Consumers,
+		Status:     status,
+		Output:     output,
+		AppVersion: ma.AppVersion,
+		Tags:       ma.Tags,
+		Weight:     ma.Weight,
+		Version:    s.nextVersion(),
+	}
+}
+
+func (s *DiscoverdRepo) Deregister(addr string) bool {
+	s.logger.Printf("[INFO] ds.msd: Deregistering app:%s", addr)
+	if _, found := s.apps.Get(addr); !found {
+		return false
+	}
+	s.apps.Delete(addr)
+	s.persist()
+	metrics.IncrCounter([]string{"discoverd", "deregister"}, 1)
+
+	s.checkLock.Lock()
+	s.stopCheck(addr)
+	s.checkLock.Unlock()
+
+	version := s.nextVersion()
+	zones := s.nodeZones()
+	s.rtLock.Lock()
+	s.setTombstone(addr, version)
+	s.notifyChange(s.removeApp(addr), zones)
+	s.rtLock.Unlock()
+
+	err := s.cluster.UnregisterService(addr, version, true)
+	if err != nil {
+		s.logger.Printf("[ERR] msd.repo: Failed to send unregister event:%s", err)
+	}
+	return true
+}
+
+// Leave unregisters every local app from the cluster before the agent
+// leaves and returns their addrs. The apps stay registered with the agent,
+// so they are restored if it restarts before their TTL lapses.
+func (s *DiscoverdRepo) Leave() []string {
+	s.StopChecks()
+
+	var addrs []string
+	zones := s.nodeZones()
+	for _, ma := range s.ListMicroApps() {
+		if _, found := s.apps.Get(ma.Addr); !found {
+			continue
+		}
+		s.logger.Printf("[INFO] ds.msd: Unregistering app:%s on leave", ma.Addr)
+		version := s.nextVersion()
+		s.rtLock.Lock()
+		s.setTombstone(ma.Addr, version)
+		s.notifyChange(s.removeApp(ma.Addr), zones)
+		s.rtLock.Unlock()
+
+		// not coalesced, the peers would only apply the last app of the
+		// burst
+		err := s.cluster.UnregisterService(ma.Addr, version, false)
+		if err != nil {
+			s.logger.Printf("[ERR] msd.repo: Failed to send unregister event:%s", err)
+		}
+		addrs = append(addrs, ma.Addr)
+	}
+	return addrs
 }
 
+// IsRegistered tells whether an app is registered with this agent.
+func (s *DiscoverdRepo) IsRegistered(addr string) bool {
+	_, found := s.apps.Get(addr)
+	return found
+}
+
+// ListMicroApps returns the local apps with their health, sorted by addr.
 func (s *DiscoverdRepo) ListMicroApps() []api.MicroApp {
-	ms := make([]api.MicroApp, s.apps.ItemCount())
-	idx := 0
-	for _, item := range s.apps.Items() {
-		ma := item.Object.(*api.MicroApp)
-		ms[idx] = *ma
-		idx++
+	items := s.apps.CopyItems()
+	ms := make(appsByAddr, 0, len(items))
+	for _, item := range items {
+		ma := *item.Object.(*api.MicroApp)
+		ma.Health = s.health(ma.Addr)
+		ms = append(ms, ma)
 	}
+	sort.Sort(ms)
 	return ms
 }
 
@@ -90,45 +342,227 @@ func (s *DiscoverdRepo) ListRouters() []api.Router {
 
 func (s *DiscoverdRepo) UpdateRouters(rs []api.Router) {
 	s.logger.Printf("[INFO] ds.msd: Updating router table")
-	s.rtLock.RLock()
-	defer s.rtLock.RUnlock()
+	zones := s.nodeZones()
+	s.rtLock.Lock()
+	defer s.rtLock.Unlock()
 
+	var changed []string
 	for k := range s.routers {
+		changed = append(changed, k)
 		delete(s.routers, k)
 	}
 	for _, v := range rs {
+		for _, na := range v.Addrs {
+			s.witness(na.Version)
+		}
+		// the peer may run another checksum version
+		v.Checksum = s.calcChecksum(v.Service, v.Addrs)
+		changed = append(changed, v.Service)
 		s.routers[v.Service] = v
 	}
+	s.notifyChange(changed, zones)
+}
+
+// MergeRouters merges the routers of a peer into the local router table.
+// Instances this agent does not know yet are added. When both sides know
+// an instance, the registration with the highest version wins, the local
+// one is kept on a tie.
+func (s *DiscoverdRepo) MergeRouters(rs []api.Router) {
+	s.logger.Printf("[INFO] ds.msd: Merging router table")
+	zones := s.nodeZones()
+	s.rtLock.Lock()
+	defer s.rtLock.Unlock()
+
+	var changed []string
+	for _, r := range rs {
+		local := s.routers[r.Service]
+		known := make(map[string]int, len(local.Addrs))
+		for idx, na := range local.Addrs {
+			known[na.Addr] = idx
+		}
+
+		addrs := make([]api.NodeAddr, len(local.Addrs), len(local.Addrs)+len(r.Addrs))
+		copy(addrs, local.Addrs)
+		modified := false
+		for _, na := range r.Addrs {
+			s.witness(na.Version)
+			if s.isStale(na.Addr, na.Version) {
+				continue
+			}
+
+			idx, exist := known[na.Addr]
+			if !exist {
+				known[na.Addr] = len(addrs)
+				addrs = append(addrs, na)
+				modified = true
+			} else if na.Version > addrs[idx].Version ||
+				(addrs[idx].Stale && !na.Stale && na.Version == addrs[idx].Version) {
+				// a live peer confirms an entry of the snapshot
+				addrs[idx] = na
+				modified = true
+			}
+		}
+		if !modified {
+			continue
+		}
+
+		changed = append(changed, r.Service)
+		s.routers[r.Service] = api.Router{
+			Service:  r.Service,
+			Addrs:    addrs,
+			Checksum: s.calcChecksum(r.Service, addrs),
+		}
+	}
+	s.notifyChange(changed, zones)
 }
 
 func (s *DiscoverdRepo) Refresh(addr string) *api.AppStatus {
 	s.logger.Printf("[INFO] ds.msd: Refreshing app at:%s|", addr)
 	isLive := s.apps.Refresh(addr, cache.DefaultExpiration)
+	if isLive {
+		metrics.IncrCounter([]string{"discoverd", "refresh"}, 1)
+	} else {
+		metrics.IncrCounter([]string{"discoverd", "refresh", "miss"}, 1)
+	}
 
+	zones := s.nodeZones()
+	s.rtLock.RLock()
+	defer s.rtLock.RUnlock()
 	return &api.AppStatus{
-		IsLive:   isLive,
-		RouterCS: s.calcRouterCheckSum(addr),
+		IsLive:          isLive,
+		RouterCS:        s.calcRouterCheckSum(addr, zones),
+		ChecksumVersion: api.ChecksumVersion,
+	}
+}
+
+// GetRouter returns the router of a service, looked up case insensitively
+// if there is no exact match. Only passing instances are returned unless
+// all is set.
+func (s *DiscoverdRepo) GetRouter(service string, all bool) (api.Router, bool) {
+	router, exist := s.findRouter(service)
+	if !exist {
+		s.lookupMissing(service)
+		return router, false
+	}
+	if !all {
+		router = passingRouter(router)
+	}
+	return router, true
+}
+
+// findRouter returns the router of a service, matching its name case
+// insensitively if there is no exact match.
+func (s *DiscoverdRepo) findRouter(service string) (api.Router, bool) {
+	s.rtLock.RLock()
+	defer s.rtLock.RUnlock()
+
+	router, exist := s.routers[service]
+	if !exist {
+		for ms, r := range s.routers {
+			if strings.EqualFold(ms, service) {
+				router, exist = r, true
+				break
+			}
+		}
 	}
+	return router, exist
 }
 
-func (s *DiscoverdRepo) GetRouterTable(addr string) *api.RouterTable {
+// GetRouterTable returns the routers of the services consumed by the app
+// at addr. Only passing instances are returned unless all is set.
+func (s *DiscoverdRepo) GetRouterTable(addr string, all bool) *api.RouterTable {
+	zones := s.nodeZones()
 	s.rtLock.RLock()
 	defer s.rtLock.RUnlock()
-	return s.calcRouterTable(addr)
+	return s.calcRouterTable(addr, all, zones)
+}
+
+// WaitRouterTable is the blocking version of GetRouterTable. It holds the
+// caller until the checksum of the router table of the app differs from
+// the given one, or the wait expires.
+func (s *DiscoverdRepo) WaitRouterTable(addr string, all bool, checksum string, wait time.Duration) *api.RouterTable {
+	timeout := time.After(wait)
+	for {
+		zones := s.nodeZones()
+		s.rtLock.RLock()
+		rt := s.calcRouterTable(addr, all, zones)
+		changeCh := s.changeCh
+		s.rtLock.RUnlock()
+
+		if rt == nil || rt.Checksum != checksum {
+			return rt
+		}
+
+		select {
+		case <-changeCh:
+		case <-timeout:
+			return rt
+		case <-s.stopCh:
+			return rt
+		}
+	}
+}
+
+// Shutdown stops the checks of the local apps and releases the blocking
+// fetches of router tables.
+func (s *DiscoverdRepo) Shutdown() {
+	s.StopChecks()
+	s.persist()
+	s.persistRouters()
+
+	s.rtLock.Lock()
+	defer s.rtLock.Unlock()
+	select {
+	case <-s.stopCh:
+	default:
+		close(s.stopCh)
+		s.closeWatchers()
+	}
+}
+
+// notifyChange wakes up the blocking fetches and streams the changed
+// services to the watchers. It must be called with the rtLock held, zones
+// are listed by nodeZones before taking it.
+func (s *DiscoverdRepo) notifyChange(changed []string, zones map[string]string) {
+	if len(changed) == 0 {
+		return
+	}
+	if !s.disableServiceMetrics {
+		for _, service := range changed {
+			metrics.SetGaugeWithLabels([]string{"discoverd", "router", "instances"},
+				float32(len(s.routers[service].Addrs)),
+				[]metrics.Label{{Name: "service", Value: service}})
+		}
+	}
+	close(s.changeCh)
+	s.changeCh = make(chan struct{})
+	s.notifyWatchers(changed, zones)
 }
 
 func (s *DiscoverdRepo) RemoveRouterByHost(node string) {
 	s.logger.Printf("[INFO] ds.msd: Removing router by host:%s", node)
+	zones := s.nodeZones()
 	s.rtLock.Lock()
 	defer s.rtLock.Unlock()
+	for addr, ac := range s.consumers {
+		if ac.node == node {
+			delete(s.consumers, addr)
+		}
+	}
+
+	var changed []string
 	for k, v := range s.routers {
-		addrs := v.Addrs
-		size := len(addrs)
-		for idx := size - 1; idx >= 0; idx-- {
-			if addrs[idx].Node == node {
-				addrs = append(addrs[:idx], addrs[idx+1:]...)
+		// copy on write, router tables may still be read by fetches
+		addrs := make([]api.NodeAddr, 0, len(v.Addrs))
+		for _, na := range v.Addrs {
+			if na.Node != node {
+				addrs = append(addrs, na)
 			}
 		}
+		if len(addrs) == len(v.Addrs) {
+			continue
+		}
+		changed = append(changed, k)
 
 		if len(addrs) == 0 {
 			delete(s.routers, k)
@@ -136,26 +570,55 @@ func (s *DiscoverdRepo) RemoveRouterByHost(node string) {
 			s.routers[k] = api.Router{
 				Service:  k,
 				Addrs:    addrs,
-				Checksum: s.calcChecksum(addrs),
+				Checksum: s.calcChecksum(k, addrs),
 			}
 		}
 	}
+	s.notifyChange(changed, zones)
 }
 
-func (s *DiscoverdRepo) RemoveRouter(addr string) {
+// RemoveRouter removes the addr unregistered at the given version. It is
+// ignored if the addr registered again since.
+func (s *DiscoverdRepo) RemoveRouter(addr string, version uint64) {
 	s.logger.Printf("[INFO] ds.msd: Removing router by addr:%s", addr)
-	s.removeRouter(addr)
+	zones := s.nodeZones()
+	s.rtLock.Lock()
+	defer s.rtLock.Unlock()
+
+	s.witness(version)
+	if version != 0 {
+		if v, found := s.routerVersion(addr); found && v > version {
+			s.logger.Printf("[INFO] ds.msd: Ignoring stale unregistration of addr:%s", addr)
+			return
+		}
+		s.setTombstone(addr, version)
+	}
+	s.notifyChange(s.removeApp(addr), zones)
 }
 
-func (s *DiscoverdRepo) removeRouter(addr string) {
+// removeApp removes an unregistered addr from every router and from the
+// dependency graph, and returns the services that changed.
+func (s *DiscoverdRepo) removeApp(addr string) []string {
+	delete(s.consumers, addr)
+	return s.removeRouter(addr)
+}
+
+// removeRouter removes the addr from every router and returns the
+// services that changed.
+func (s *DiscoverdRepo) removeRouter(addr string) []string {
+	var changed []string
 	for ms, router := range s.routers {
-		addrs := router.Addrs
-		size := len(addrs)
-		for idx := size - 1; idx >= 0; idx-- {
-			if addrs[idx].Addr == addr {
-				addrs = append(addrs[:idx], addrs[idx+1:]...)
+		// copy on write, router tables may still be read by fetches
+		addrs := make([]api.NodeAddr, 0, len(router.Addrs))
+		for _, na := range router.Addrs {
+			if na.Addr != addr {
+				addrs = append(addrs, na)
 			}
 		}
+		if len(addrs) == len(router.Addrs) {
+			continue
+		}
+		changed = append(changed, ms)
 
 		if len(addrs) == 0 {
 			delete(s.routers, ms)
@@ -163,69 +626,160 @@ func (s *DiscoverdRepo) removeRouter(addr string) {
 			s.routers[ms] = api.Router{
 				Service:  ms,
 				Addrs:    addrs,
-				Checksum: s.calcChecksum(addrs),
+				Checksum: s.calcChecksum(ms, addrs),
 			}
 		}
 	}
+	return changed
+}
+
+// AddRouter adds the registration of an app providing the services mss
+// and consuming the services consumers.
+func (s *DiscoverdRepo) AddRouter(na api.NodeAddr, mss []string, consumers []string) {
+	s.addRouter(na, mss, consumers, false)
 }
 
-func (s *DiscoverdRepo) AddRouter(node string, addr string, mss []string) {
-	s.logger.Printf("[INFO] ds.msd: Adding router:%s,%s{%v}", node, addr, mss)
+// AddRouterWithoutConsumers adds a registration gossiped without its
+// consumers, the ones known for the app are kept.
+func (s *DiscoverdRepo) AddRouterWithoutConsumers(na api.NodeAddr, mss []string) {
+	s.addRouter(na, mss, nil, true)
+}
+
+func (s *DiscoverdRepo) addRouter(na api.NodeAddr, mss []string, consumers []string, omitted bool) {
+	s.logger.Printf("[INFO] ds.msd: Adding router:%s,%s,%s{%v}", na.Node, na.Addr, na.Status, mss)
 
+	zones := s.nodeZones()
 	s.rtLock.Lock()
 	defer s.rtLock.Unlock()
 
+	s.witness(na.Version)
+	if s.isStale(na.Addr, na.Version) {
+		s.logger.Printf("[INFO] ds.msd: Ignoring stale registration of addr:%s", na.Addr)
+		return
+	}
+	s.tombstones.Delete(na.Addr)
+
 	// for shutdown micro app and upgrade very quickly.
-	s.removeRouter(addr)
+	changed := s.removeRouter(na.Addr)
+	s.setConsumers(na, consumers, omitted)
 	for _, ms := range mss {
+		changed = append(changed, ms)
 		router, exist := s.routers[ms]
 		if !exist {
-			nas := []api.NodeAddr{api.NodeAddr{Node: node, Addr: addr}}
+			nas := []api.NodeAddr{na}
 			s.routers[ms] = api.Router{
 				Service:  ms,
 				Addrs:    nas,
-				Checksum: s.calcChecksum(nas),
+				Checksum: s.calcChecksum(ms, nas),
 			}
 		} else {
 			addrs := router.Addrs
 			var isExist bool
 			for _, v := range addrs {
-				if v.Addr == addr {
+				if v.Addr == na.Addr {
 					isExist = true
 					break
 				}
 			}
 			if !isExist {
-				addrs = append(addrs, api.NodeAddr{Node: node, Addr: addr})
+				addrs = append(addrs, na)
 				s.routers[ms] = api.Router{
 					Service:  ms,
 					Addrs:    addrs,
-					Checksum: s.calcChecksum(addrs),
+					Checksum: s.calcChecksum(ms, addrs),
 				}
 			}
 		}
 	}
+	s.notifyChange(changed, zones)
 }
 
-func (s *DiscoverdRepo) calcRouterCheckSum(addr string) string {
-	ck := make([]byte, 16)
-	ma, found := s.apps.Get(addr)
-	if found {
-		app := ma.(*api.MicroApp)
-		for _, v := range app.Consumers {
-			rt, exist := s.routers[v]
-			if !exist {
-				continue
-			}
-			for idx := 0; idx < 16; idx++ {
-				ck[idx] += rt.Checksum[idx]
+// ListTombstones returns the unregistrations remembered by the agent, so
+// a peer which missed them can catch up.
+func (s *DiscoverdRepo) ListTombstones() []api.InnerAppUnregister {
+	s.rtLock.RLock()
+	defer s.rtLock.RUnlock()
+
+	var ts []api.InnerAppUnregister
+	for addr, item := range s.tombstones.CopyItems() {
+		if !item.Expired() {
+			ts = append(ts, api.InnerAppUnregister{Addr: addr, Version: item.Object.(uint64)})
+		}
+	}
+	return ts
+}
+
+// MergeTombstones applies the unregistrations of a peer, the ones older
+// than the registration of their addr are ignored.
+func (s *DiscoverdRepo) MergeTombstones(ts []api.InnerAppUnregister) {
+	zones := s.nodeZones()
+	s.rtLock.Lock()
+	defer s.rtLock.Unlock()
+
+	var changed []string
+	for _, t := range ts {
+		s.witness(t.Version)
+		if v, found := s.routerVersion(t.Addr); found && v > t.Version {
+			continue
+		}
+		s.setTombstone(t.Addr, t.Version)
+		changed = append(changed, s.removeApp(t.Addr)...)
+	}
+	if len(changed) > 0 {
+		s.logger.Printf("[INFO] ds.msd: Merged tombstones removed %d routers", len(changed))
+	}
+	s.notifyChange(changed, zones)
+}
+
+func (s *DiscoverdRepo) nextVersion() uint64 {
+	return uint64(s.clock.Increment())
+}
+
+func (s *DiscoverdRepo) witness(version uint64) {
+	if version != 0 {
+		s.clock.Witness(serf.LamportTime(version))
+	}
+}
+
+// setTombstone must be called with the rtLock held.
+func (s *DiscoverdRepo) setTombstone(addr string, version uint64) {
+	if v, found := s.tombstones.Get(addr); found && v.(uint64) >= version {
+		return
+	}
+	s.tombstones.Set(addr, version, cache.DefaultExpiration)
+}
+
+// routerVersion returns the version the addr is registered at in the
+// router table. It must be called with the rtLock held.
+func (s *DiscoverdRepo) routerVersion(addr string) (uint64, bool) {
+	for _, router := range s.routers {
+		for _, na := range router.Addrs {
+			if na.Addr == addr {
+				return na.Version, true
 			}
 		}
 	}
-	return hex.EncodeToString(ck)
+	return 0, false
+}
+
+// isStale tells whether a registration of the addr is older than what the
+// repo holds for it. Unversioned registrations are never stale. It must be
+// called with the rtLock held.
+func (s *DiscoverdRepo) isStale(addr string, version uint64) bool {
+	if version == 0 {
+		return false
+	}
+	if v, found := s.tombstones.Get(addr); found && v.(uint64) >= version {
+		return true
+	}
+	v, found := s.routerVersion(addr)
+	return found && v > version
 }
 
-func (s *DiscoverdRepo) calcRouterTable(addr string) *api.RouterTable {
+// calcRouterTable returns the router table of the app at addr, nil if it
+// is not registered. zones is the zone of every node, as returned by
+// nodeZones. It must be called with the rtLock held.
+func (s *DiscoverdRepo) calcRouterTable(addr string, all bool, zones map[string]string) *api.RouterTable {
 	ma, found := s.apps.Get(addr)
 	if !found {
 		return nil
@@ -236,39 +790,39 @@ func (s *DiscoverdRepo) calcRouterTable(addr string) *api.RouterTable {
 
 	app := ma.(*api.MicroApp)
 	for _, v := range app.Consumers {
-		router, exist := s.routers[v]
+		router, exist := s.consumedRouter(app, v, all, zones)
 		if !exist {
+			s.lookupMissing(v)
+		}
+		if !exist || (!all && len(router.Addrs) == 0) {
 			continue
 		}
 		routers = append(routers, router)
 	}
 	if len(routers) > 0 {
-		ck := make([]byte, 16)
-		for _, rt := range routers {
-			for idx := 0; idx < 16; idx++ {
-				ck[idx] += rt.Checksum[idx]
-			}
-		}
-
-		checksum = hex.EncodeToString(ck)
+		checksum = calcTableChecksum(routers)
 	}
 
 	return &api.RouterTable{
-		Routers:  routers,
-		Checksum: checksum,
+		Routers:         routers,
+		Checksum:        checksum,
+		ChecksumVersion: api.ChecksumVersion,
 	}
 }
 
-func (s *DiscoverdRepo) calcChecksum(ss []api.NodeAddr) []byte {
-	hasher := md5.New()
-	sum := make([]byte, 16)
-	for _, s := range ss {
-		hasher.Reset()
-		hasher.Write([]byte(s.Addr))
-		cs := hasher.Sum(nil)
-		for idx := 0; idx < 16; idx++ {
-			sum[idx] += cs[idx]
+// passingRouter returns a copy of the router without the instances that
+// are not passing. The checksum still covers every instance so that a
+// change of health is seen by consumers.
+func passingRouter(r api.Router) api.Router {
+	addrs := make([]api.NodeAddr, 0, len(r.Addrs))
+	for _, na := range r.Addrs {
+		if na.Status == "" || na.Status == check.HealthPassing {
+			addrs = append(addrs, na)
 		}
 	}
-	return sum
+	return api.Router{
+		Service:  r.Service,
+		Addrs:    addrs,
+		Checksum: r.Checksum,
+	}
 }
@@ -1,12 +1,53 @@
 package msd
 
 import (
-	"blued/discoverd/api"
 	"encoding/hex"
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/cluster"
 	"testing"
 	"time"
 )
 
+// loopbackCluster applies the registrations of the repo to itself, as
+// serf delivers the user events to the node sending them.
+type loopbackCluster struct {
+	cluster.MockCluster
+	node string
+	repo *DiscoverdRepo
+}
+
+func (c *loopbackCluster) RegisterService(ss *api.AppService, coalesce bool) error {
+	c.repo.AddRouter(api.NodeAddr{
+		Node:       c.node,
+		Addr:       ss.Addr,
+		Status:     ss.Status,
+		Output:     ss.Output,
+		AppVersion: ss.AppVersion,
+		Tags:       ss.Tags,
+		Weight:     ss.Weight,
+		Version:    ss.Version,
+	}, ss.Services, ss.Consumers)
+	return nil
+}
+
+func (c *loopbackCluster) UnregisterService(addr string, version uint64, coalesce bool) error {
+	go c.repo.RemoveRouter(addr, version)
+	return nil
+}
+
+func (c *loopbackCluster) LocalNode() string {
+	return c.node
+}
+
+func createDiscoverdRepo(conf *Config) *DiscoverdRepo {
+	if conf == nil {
+		conf = &Config{TTL: time.Second, TombstoneTTL: time.Minute}
+	}
+	lc := &loopbackCluster{node: "node1"}
+	lc.repo = NewDiscoverdRepo(lc, conf, nil)
+	return lc.repo
+}
+
 func Test_Register(t *testing.T) {
 	sr := createDiscoverdRepo(nil)
 	mss := []string{"a.b", "a.c"}
@@ -28,7 +69,7 @@ func Test_Register(t *testing.T) {
 		}
 	}
 
-	if sr.routers["a.b"].Addrs[0] != url || sr.routers["a.c"].Addrs[0] != url {
+	if sr.routers["a.b"].Addrs[0].Addr != url || sr.routers["a.c"].Addrs[0].Addr != url {
 		t.Error("service is not register to consumer")
 	}
 }
@@ -45,7 +86,8 @@ func Test_TTL(t *testing.T) {
 		t.Errorf("si is not expirated with %d second", 1)
 	}
 
-	if _, exist := sr.routers["a.b"]; exist {
+	time.Sleep(100 * time.Millisecond)
+	if _, exist := sr.GetRouter("a.b", true); exist {
 		t.Logf("cr=%v", sr.routers)
 		t.Errorf("app is not expirated in cr with %d second", 1)
 	}
@@ -63,10 +105,10 @@ func Test_TTL(t *testing.T) {
 func Test_removeRouter(t *testing.T) {
 	sr := createDiscoverdRepo(nil)
 	url := "http://a.com:8080/rc"
-	sr.routers["a.b"] = api.Router{Service: "a.b", Addrs: []string{url}}
-	sr.routers["a.c"] = api.Router{Service: "a.c", Addrs: []string{url}}
+	sr.routers["a.b"] = api.Router{Service: "a.b", Addrs: []api.NodeAddr{{Addr: url}}}
+	sr.routers["a.c"] = api.Router{Service: "a.c", Addrs: []api.NodeAddr{{Addr: url}}}
 
-	sr.removeRouter(url, []string{"a.b", "a.c"})
+	sr.removeRouter(url)
 	if _, exist := sr.routers["a.b"]; exist {
 		t.Errorf("app is not removed in router %v", sr.routers["a.b"])
 	}
@@ -75,23 +117,165 @@ func Test_removeRouter(t *testing.T) {
 func Test_OnAppExpired(t *testing.T) {
 	sr := createDiscoverdRepo(nil)
 	url := "http://a.com:8080/rc"
-	sr.routers["a.b"] = api.Router{Service: "a.b", Addrs: []string{url}}
-	sr.routers["a.c"] = api.Router{Service: "a.c", Addrs: []string{url}}
+	sr.routers["a.b"] = api.Router{Service: "a.b", Addrs: []api.NodeAddr{{Addr: url}}}
+	sr.routers["a.c"] = api.Router{Service: "a.c", Addrs: []api.NodeAddr{{Addr: url}}}
 
 	dm := make(map[string]interface{})
 	dm[url] = &api.MicroApp{Addr: url, Providers: []string{"a.b", "a.c"}}
 	sr.OnAppExpired(dm)
 
-	if _, exist := sr.routers["a.b"]; exist {
+	time.Sleep(100 * time.Millisecond)
+	if _, exist := sr.GetRouter("a.b", true); exist {
 		t.Errorf("app is not removed in router %v", sr.routers["a.b"])
 	}
 }
 
 func Test_CalcSign(t *testing.T) {
 	sr := createDiscoverdRepo(nil)
-	s1 := hex.EncodeToString(sr.calcChecksum([]string{"a.b", "a.c"}))
-	s2 := hex.EncodeToString(sr.calcChecksum([]string{"a.c", "a.b"}))
+	a := api.NodeAddr{Node: "n1", Addr: "http://a.com:8080/rs"}
+	b := api.NodeAddr{Node: "n2", Addr: "http://b.com:8080/rs"}
+	s1 := hex.EncodeToString(sr.calcChecksum("a.b", []api.NodeAddr{a, b}))
+	s2 := hex.EncodeToString(sr.calcChecksum("a.b", []api.NodeAddr{b, a}))
 	if s1 != s2 {
 		t.Errorf("sign s1[%s] != s2[%s]", s1, s2)
 	}
 }
+
+func Test_RegisterScriptCheck(t *testing.T) {
+	ma := &api.MicroApp{
+		Addr:      "http://a.com:8080/rs",
+		Providers: []string{"a.b"},
+		Check:     &api.Check{Script: "exit 0"},
+	}
+
+	sr := createDiscoverdRepo(nil)
+	if err := sr.Register(ma); err == nil {
+		t.Fatal("script check registered while disabled")
+	}
+	if sr.IsRegistered(ma.Addr) {
+		t.Fatal("app with a script check is registered")
+	}
+
+	sr = createDiscoverdRepo(&Config{TTL: time.Second, TombstoneTTL: time.Minute, EnableScriptChecks: true})
+	defer sr.StopChecks()
+	if err := sr.Register(ma); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func Test_MergeTombstones(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 10}, []string{"a.b"}, nil)
+	sr.AddRouter(api.NodeAddr{Node: "n2", Addr: "a2", Version: 30}, []string{"a.b"}, nil)
+
+	// a1 was unregistered after its registration, a2 registered again
+	// after its unregistration
+	sr.MergeTombstones([]api.InnerAppUnregister{{Addr: "a1", Version: 20}, {Addr: "a2", Version: 25}})
+	r, _ := sr.GetRouter("a.b", true)
+	if len(r.Addrs) != 1 || r.Addrs[0].Addr != "a2" {
+		t.Fatalf("bad router: %v", r)
+	}
+
+	// the tombstone is passed on and keeps the old registration out
+	ts := sr.ListTombstones()
+	if len(ts) != 1 || ts[0].Addr != "a1" || ts[0].Version != 20 {
+		t.Fatalf("bad tombstones: %v", ts)
+	}
+	sr.MergeRouters([]api.Router{{Service: "a.b", Addrs: []api.NodeAddr{{Node: "n1", Addr: "a1", Version: 10}}}})
+	if r, _ := sr.GetRouter("a.b", true); len(r.Addrs) != 1 {
+		t.Fatalf("unregistered addr is back: %v", r)
+	}
+}
+
+func Test_StaleRegistration(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	na := api.NodeAddr{Node: "n1", Addr: "a1", Version: 10}
+	sr.AddRouter(na, []string{"a.b"}, nil)
+	sr.RemoveRouter("a1", 20)
+	if _, exist := sr.GetRouter("a.b", true); exist {
+		t.Fatal("addr is not removed")
+	}
+
+	// an old registration delivered after the unregistration is ignored
+	na.Version = 15
+	sr.AddRouter(na, []string{"a.b"}, nil)
+	if _, exist := sr.GetRouter("a.b", true); exist {
+		t.Fatal("stale registration resurrected the addr")
+	}
+
+	// a newer registration wins over the tombstone
+	na.Version = 25
+	sr.AddRouter(na, []string{"a.b"}, nil)
+	if r, _ := sr.GetRouter("a.b", true); len(r.Addrs) != 1 || r.Addrs[0].Version != 25 {
+		t.Fatalf("bad router: %v", r)
+	}
+}
+
+func Test_StaleUnregistration(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 30}, []string{"a.b"}, nil)
+	sr.RemoveRouter("a1", 20)
+	if r, _ := sr.GetRouter("a.b", true); len(r.Addrs) != 1 {
+		t.Fatalf("unregistration older than the registration removed it: %v", r)
+	}
+}
+
+func Test_MergeRoutersLTime(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Status: "passing", Version: 10}, []string{"a.b"}, nil)
+
+	sr.MergeRouters([]api.Router{{Service: "a.b", Addrs: []api.NodeAddr{
+		{Node: "n1", Addr: "a1", Status: "critical", Version: 5},
+		{Node: "n2", Addr: "a2", Version: 7},
+	}}})
+	r, _ := sr.GetRouter("a.b", true)
+	if len(r.Addrs) != 2 || r.Addrs[0].Status != "passing" {
+		t.Fatalf("older instance won the merge: %v", r)
+	}
+
+	sr.MergeRouters([]api.Router{{Service: "a.b", Addrs: []api.NodeAddr{
+		{Node: "n1", Addr: "a1", Status: "critical", Version: 12},
+	}}})
+	r, _ = sr.GetRouter("a.b", true)
+	if r.Addrs[0].Status != "critical" || r.Addrs[0].Version != 12 {
+		t.Fatalf("newer instance lost the merge: %v", r)
+	}
+}
+
+func Test_TombstoneExpiry(t *testing.T) {
+	sr := createDiscoverdRepo(&Config{TTL: time.Second, TombstoneTTL: 100 * time.Millisecond})
+	sr.RemoveRouter("a1", 20)
+	time.Sleep(200 * time.Millisecond)
+
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 15}, []string{"a.b"}, nil)
+	if _, exist := sr.GetRouter("a.b", true); !exist {
+		t.Fatal("expired tombstone still rejects the addr")
+	}
+}
+
+func Test_WitnessVersion(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	ltime := uint64(time.Now().UnixNano()) + uint64(time.Hour)
+	sr.AddRouter(api.NodeAddr{Node: "n2", Addr: "a1", Version: ltime}, []string{"a.b"}, nil)
+	if v := sr.nextVersion(); v <= ltime {
+		t.Fatalf("version %d is not after the witnessed %d", v, ltime)
+	}
+}
+
+func Test_LeaveNotCoalesced(t *testing.T) {
+	sr, cc := createCoalesceRepo()
+	sr.Register(&api.MicroApp{Addr: "a1", Providers: []string{"a.b"}})
+	sr.Register(&api.MicroApp{Addr: "a2", Providers: []string{"a.c"}})
+
+	// the peers would only apply the last unregistration of a coalesced burst
+	if addrs := sr.Leave(); len(addrs) != 2 {
+		t.Fatalf("bad addrs: %v", addrs)
+	}
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	for _, addr := range []string{"a1", "a2"} {
+		if coalesced, sent := cc.coalesced[addr]; !sent || coalesced {
+			t.Fatalf("unregistration of %s coalesced", addr)
+		}
+	}
+}
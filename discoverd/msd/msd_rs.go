@@ -4,8 +4,21 @@ import (
 	"encoding/base64"
 	"github.com/bluefw/blued/discoverd/api"
 	"github.com/gin-gonic/gin"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxWait caps the duration a blocking fetch of a router table
+	// is held by the agent.
+	maxWait = 10 * time.Minute
+
+	// legacyKey flags the requests of the routes before v1 in the gin
+	// context, they keep answering with the status codes they used to.
+	legacyKey = "legacy"
 )
 
 type ServiceResource struct {
@@ -20,33 +33,222 @@ func NewServiceResource(dr *DiscoverdRepo, l *log.Logger) *ServiceResource {
 	}
 }
 
+// Legacy adapts a handler to the routes before v1, which answer with the
+// status codes they used to.
+func Legacy(h gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(legacyKey, true)
+		h(c)
+	}
+}
+
+// LegacyAddr adapts a handler addressing the app with the addr query
+// parameter to the routes before v1 taking it base64 encoded in the path.
+func LegacyAddr(h gin.HandlerFunc) gin.HandlerFunc {
+	return Legacy(func(c *gin.Context) {
+		addr, err := base64.StdEncoding.DecodeString(c.Params.ByName("addr"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, api.NewError(api.ErrBadRequest, "error decoding addr"))
+			return
+		}
+		q := c.Request.URL.Query()
+		q.Set("addr", string(addr))
+		c.Request.URL.RawQuery = q.Encode()
+		h(c)
+	})
+}
+
+func isLegacy(c *gin.Context) bool {
+	_, legacy := c.Get(legacyKey)
+	return legacy
+}
+
+// appAddr returns the addr query parameter, answering a bad request if
+// it is missing.
+func appAddr(c *gin.Context) (string, bool) {
+	addr := c.Query("addr")
+	if addr == "" {
+		c.JSON(http.StatusBadRequest, api.NewError(api.ErrBadRequest, "missing addr"))
+		return "", false
+	}
+	return addr, true
+}
+
 func (sr *ServiceResource) RegMicroApp(c *gin.Context) {
 	var as api.MicroApp
 	if err := c.Bind(&as); err != nil {
-		c.JSON(http.StatusBadRequest, api.NewError("problem decoding body"))
+		c.JSON(http.StatusBadRequest, api.NewError(api.ErrBadRequest, "problem decoding body"))
 		return
 	}
 
-	sr.repo.Register(&as)
+	if err := sr.repo.Register(&as); err != nil {
+		status, code := http.StatusUnprocessableEntity, api.ErrInvalidApp
+		if err == ErrConflict {
+			status, code = http.StatusConflict, api.ErrConflict
+		}
+		if isLegacy(c) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, api.NewError(code, err.Error()))
+		return
+	}
 	c.JSON(http.StatusCreated, nil)
 }
 
-func (sr *ServiceResource) GetRouterTable(c *gin.Context) {
-	addr, err := base64.StdEncoding.DecodeString(c.Params.ByName("addr"))
+func (sr *ServiceResource) DeregMicroApp(c *gin.Context) {
+	addr, ok := appAddr(c)
+	if !ok {
+		return
+	}
+	if !sr.repo.Deregister(addr) {
+		c.JSON(http.StatusNotFound, api.NewError(api.ErrAppNotFound, "app is not registered"))
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+// Maintenance puts the app, or every app of the agent if no addr is given,
+// into maintenance with the reason query parameter, or takes them out of
+// maintenance, as set by the enable query parameter. It answers with the
+// addrs of the apps.
+func (sr *ServiceResource) Maintenance(c *gin.Context) {
+	enable, err := strconv.ParseBool(c.Query("enable"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, api.NewError("error decoding addr"))
+		c.JSON(http.StatusBadRequest, api.NewError(api.ErrBadRequest, "error decoding enable"))
+		return
+	}
+	reason := c.Query("reason")
+
+	addr := c.Query("addr")
+	if addr == "" {
+		c.JSON(http.StatusOK, sr.repo.SetNodeMaintenance(enable, reason))
+		return
+	}
+	if !sr.repo.SetMaintenance(addr, enable, reason) {
+		c.JSON(http.StatusNotFound, api.NewError(api.ErrAppNotFound, "app is not registered"))
+		return
+	}
+	c.JSON(http.StatusOK, []string{addr})
+}
+
+func (sr *ServiceResource) GetRouterTable(c *gin.Context) {
+	addr, ok := appAddr(c)
+	if !ok {
+		return
+	}
+	all := c.Query("all") == "true"
+
+	var rt *api.RouterTable
+	if raw := c.Query("wait"); raw != "" {
+		// A wait turns the fetch into a blocking one, which returns as
+		// soon as the router table no longer matches the client's checksum.
+		wait, err := time.ParseDuration(raw)
+		if err != nil || wait < 0 {
+			c.JSON(http.StatusBadRequest, api.NewError(api.ErrBadRequest, "error decoding wait"))
+			return
+		}
+		if wait > maxWait {
+			wait = maxWait
+		}
+		rt = sr.repo.WaitRouterTable(addr, all, c.Query("checksum"), wait)
+	} else {
+		rt = sr.repo.GetRouterTable(addr, all)
+	}
+
+	// the routes before v1 answer null for apps which are not registered
+	if rt == nil && !isLegacy(c) {
+		c.JSON(http.StatusNotFound, api.NewError(api.ErrAppNotFound, "app is not registered"))
 		return
 	}
-	rt := sr.repo.GetRouterTable(string(addr))
 	c.JSON(http.StatusOK, rt)
 }
 
+// WatchRouterTable streams the router changes of the services consumed
+// by the app as server-sent events until the client goes away.
+func (sr *ServiceResource) WatchRouterTable(c *gin.Context) {
+	addr, ok := appAddr(c)
+	if !ok {
+		return
+	}
+	if !sr.repo.IsRegistered(addr) && !isLegacy(c) {
+		c.JSON(http.StatusNotFound, api.NewError(api.ErrAppNotFound, "app is not registered"))
+		return
+	}
+	all := c.Query("all") == "true"
+
+	w := sr.repo.Watch(addr, all)
+	defer sr.repo.Unwatch(w)
+
+	clientGone := c.Writer.CloseNotify()
+	c.Stream(func(out io.Writer) bool {
+		select {
+		case e, ok := <-w.EventCh():
+			if !ok {
+				return false
+			}
+			c.SSEvent("router", e)
+			return true
+		case <-clientGone:
+			return false
+		}
+	})
+}
+
+// Refresh renews the TTL of the app. An app that is no longer live is not
+// found and should register again, the routes before v1 tell it so in the
+// answer.
 func (sr *ServiceResource) Refresh(c *gin.Context) {
-	addr, err := base64.StdEncoding.DecodeString(c.Params.ByName("addr"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, api.NewError("error decoding addr"))
+	addr, ok := appAddr(c)
+	if !ok {
+		return
+	}
+	appStatus := sr.repo.Refresh(addr)
+	if !appStatus.IsLive && !isLegacy(c) {
+		c.JSON(http.StatusNotFound, api.NewError(api.ErrAppNotFound, "app is not registered"))
 		return
 	}
-	appStatus := sr.repo.Refresh(string(addr))
 	c.JSON(http.StatusAccepted, appStatus)
 }
+
+// ListServices lists the services of the catalog with their instance
+// counts.
+func (sr *ServiceResource) ListServices(c *gin.Context) {
+	c.JSON(http.StatusOK, sr.repo.ListServices())
+}
+
+// ServiceInstances lists the instances of a service, whatever their
+// health.
+func (sr *ServiceResource) ServiceInstances(c *gin.Context) {
+	addrs, found := sr.repo.ServiceInstances(c.Params.ByName("service"))
+	if !found {
+		c.JSON(http.StatusNotFound, api.NewError(api.ErrServiceNotFound, "service not found"))
+		return
+	}
+	c.JSON(http.StatusOK, addrs)
+}
+
+// ListMicroApps lists the apps registered with this agent.
+func (sr *ServiceResource) ListMicroApps(c *gin.Context) {
+	c.JSON(http.StatusOK, sr.repo.ListMicroApps())
+}
+
+// NodeServices lists the instances provided by a node.
+func (sr *ServiceResource) NodeServices(c *gin.Context) {
+	c.JSON(http.StatusOK, sr.repo.NodeServices(c.Params.ByName("node")))
+}
+
+// Dependencies lists the providers and consumers of every service of the
+// cluster.
+func (sr *ServiceResource) Dependencies(c *gin.Context) {
+	c.JSON(http.StatusOK, sr.repo.Dependencies())
+}
+
+// ServiceDependencies lists the providers and consumers of a service.
+func (sr *ServiceResource) ServiceDependencies(c *gin.Context) {
+	sd, found := sr.repo.ServiceDependencies(c.Params.ByName("service"))
+	if !found {
+		c.JSON(http.StatusNotFound, api.NewError(api.ErrServiceNotFound, "service not found"))
+		return
+	}
+	c.JSON(http.StatusOK, sd)
+}
@@ -0,0 +1,52 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"sort"
+	"time"
+)
+
+// RTT estimates the round trip time from the local node to a node, false
+// if the network coordinates of the node are unknown.
+func (s *DiscoverdRepo) RTT(node string) (time.Duration, bool) {
+	return s.cluster.RTT(node)
+}
+
+// sortByRTT returns a copy of the router with its instances sorted by the
+// estimated round trip time to their node, nearest first. Each instance
+// carries its RTT, the ones with an unknown RTT come last.
+func (s *DiscoverdRepo) sortByRTT(r api.Router) api.Router {
+	addrs := make(addrsByRTT, len(r.Addrs))
+	copy(addrs, r.Addrs)
+
+	rtts := make(map[string]*float64)
+	for idx, na := range addrs {
+		rtt, cached := rtts[na.Node]
+		if !cached {
+			if d, ok := s.cluster.RTT(na.Node); ok {
+				ms := d.Seconds() * 1000
+				rtt = &ms
+			}
+			rtts[na.Node] = rtt
+		}
+		addrs[idx].RTT = rtt
+	}
+	sort.Stable(addrs)
+
+	return api.Router{
+		Service:  r.Service,
+		Addrs:    addrs,
+		Checksum: r.Checksum,
+	}
+}
+
+type addrsByRTT []api.NodeAddr
+
+func (a addrsByRTT) Len() int      { return len(a) }
+func (a addrsByRTT) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a addrsByRTT) Less(i, j int) bool {
+	if a[i].RTT == nil || a[j].RTT == nil {
+		return a[j].RTT == nil && a[i].RTT != nil
+	}
+	return *a[i].RTT < *a[j].RTT
+}
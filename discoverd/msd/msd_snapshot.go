@@ -0,0 +1,182 @@
+package msd
+
+import (
+	"encoding/json"
+	"github.com/bluefw/blued/discoverd/api"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const (
+	// routerSnapshotInterval is how often the router table is snapshotted.
+	routerSnapshotInterval = 30 * time.Second
+
+	// staleTimeout is how long the entries loaded from the snapshot are
+	// kept without being confirmed by a live event or peer.
+	staleTimeout = 5 * time.Minute
+)
+
+// routerSnapshot is the content of the snapshot file. Snapshots written
+// before the consumers were kept are a bare list of routers.
+type routerSnapshot struct {
+	Routers   []api.Router       `json:"routers"`
+	Consumers []api.AppConsumers `json:"consumers,omitempty"`
+}
+
+// persistRouters writes the router table to the snapshot file.
+func (s *DiscoverdRepo) persistRouters() {
+	if s.snapshotPath == "" {
+		return
+	}
+
+	buf, err := json.Marshal(&routerSnapshot{
+		Routers:   s.ListRouters(),
+		Consumers: s.ListConsumers(),
+	})
+	if err != nil {
+		s.logger.Printf("[ERR] msd.repo: Failed to snapshot router table:%s", err)
+		return
+	}
+
+	s.persistLock.Lock()
+	defer s.persistLock.Unlock()
+
+	tmp := s.snapshotPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		s.logger.Printf("[ERR] msd.repo: Failed to snapshot router table:%s", err)
+		return
+	}
+	if err := os.Rename(tmp, s.snapshotPath); err != nil {
+		s.logger.Printf("[ERR] msd.repo: Failed to snapshot router table:%s", err)
+	}
+}
+
+// snapshotRouters periodically snapshots the router table until the repo
+// is shut down.
+func (s *DiscoverdRepo) snapshotRouters() {
+	ticker := time.NewTicker(routerSnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.persistRouters()
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// loadRouters loads the router table of the snapshot. Its entries are
+// marked stale until an event or a peer confirms them, and are dropped
+// if none does within the staleTimeout.
+func (s *DiscoverdRepo) loadRouters() error {
+	buf, err := ioutil.ReadFile(s.snapshotPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var snap routerSnapshot
+	if err := json.Unmarshal(buf, &snap); err != nil {
+		if err := json.Unmarshal(buf, &snap.Routers); err != nil {
+			return err
+		}
+	}
+	rs := snap.Routers
+
+	s.rtLock.Lock()
+	defer s.rtLock.Unlock()
+
+	// the addrs known before the load win over the snapshot, an addr of
+	// the snapshot is loaded for every service it provides
+	known := make(map[string]bool)
+	for _, router := range s.routers {
+		for _, na := range router.Addrs {
+			known[na.Addr] = true
+		}
+	}
+
+	loaded := 0
+	for _, r := range rs {
+		addrs := make([]api.NodeAddr, 0, len(r.Addrs))
+		for _, na := range r.Addrs {
+			s.witness(na.Version)
+			if known[na.Addr] {
+				continue
+			}
+			na.Stale = true
+			addrs = append(addrs, na)
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		loaded += len(addrs)
+		if router, exist := s.routers[r.Service]; exist {
+			addrs = append(addrs, router.Addrs...)
+		}
+		s.routers[r.Service] = api.Router{
+			Service:  r.Service,
+			Addrs:    addrs,
+			Checksum: s.calcChecksum(r.Service, addrs),
+		}
+	}
+	for _, c := range snap.Consumers {
+		s.witness(c.Version)
+		if _, exist := s.consumers[c.Addr]; exist {
+			continue
+		}
+		s.consumers[c.Addr] = appConsumers{
+			node:     c.Node,
+			services: c.Services,
+			version:  c.Version,
+			stale:    true,
+		}
+	}
+	s.logger.Printf("[INFO] ds.msd: Loaded %d instances from snapshot", loaded)
+
+	time.AfterFunc(staleTimeout, s.dropStale)
+	return nil
+}
+
+// dropStale removes the entries of the snapshot nobody confirmed.
+func (s *DiscoverdRepo) dropStale() {
+	zones := s.nodeZones()
+	s.rtLock.Lock()
+	defer s.rtLock.Unlock()
+
+	for addr, ac := range s.consumers {
+		if ac.stale {
+			delete(s.consumers, addr)
+		}
+	}
+
+	var changed []string
+	for ms, router := range s.routers {
+		// copy on write, router tables may still be read by fetches
+		addrs := make([]api.NodeAddr, 0, len(router.Addrs))
+		for _, na := range router.Addrs {
+			if !na.Stale {
+				addrs = append(addrs, na)
+			}
+		}
+		if len(addrs) == len(router.Addrs) {
+			continue
+		}
+		s.logger.Printf("[INFO] ds.msd: Dropping %d stale instances of %s", len(router.Addrs)-len(addrs), ms)
+		changed = append(changed, ms)
+
+		if len(addrs) == 0 {
+			delete(s.routers, ms)
+		} else {
+			s.routers[ms] = api.Router{
+				Service:  ms,
+				Addrs:    addrs,
+				Checksum: s.calcChecksum(ms, addrs),
+			}
+		}
+	}
+	s.notifyChange(changed, zones)
+}
@@ -0,0 +1,113 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSnapshot(t *testing.T, rs []api.Router) (*Config, func()) {
+	dir, err := ioutil.TempDir("", "msd")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	conf := &Config{TTL: time.Second, TombstoneTTL: time.Minute, RouterSnapshotPath: filepath.Join(dir, "routers")}
+	sr := createDiscoverdRepo(conf)
+	sr.MergeRouters(rs)
+	sr.persistRouters()
+	sr.Shutdown()
+	return conf, func() { os.RemoveAll(dir) }
+}
+
+func Test_LoadRoutersMultiService(t *testing.T) {
+	conf, cleanup := testSnapshot(t, []api.Router{
+		{Service: "a.b", Addrs: []api.NodeAddr{{Node: "n1", Addr: "a1", Version: 10}}},
+		{Service: "a.c", Addrs: []api.NodeAddr{{Node: "n1", Addr: "a1", Version: 10}, {Node: "n2", Addr: "a2", Version: 11}}},
+	})
+	defer cleanup()
+
+	sr := createDiscoverdRepo(conf)
+	defer sr.Shutdown()
+	for service, n := range map[string]int{"a.b": 1, "a.c": 2} {
+		r, exist := sr.GetRouter(service, true)
+		if !exist || len(r.Addrs) != n {
+			t.Fatalf("bad router of %s: %v", service, r)
+		}
+		for _, na := range r.Addrs {
+			if !na.Stale {
+				t.Fatalf("loaded instance is not stale: %v", na)
+			}
+		}
+	}
+}
+
+func Test_LoadRoutersDropStale(t *testing.T) {
+	conf, cleanup := testSnapshot(t, []api.Router{
+		{Service: "a.b", Addrs: []api.NodeAddr{{Node: "n1", Addr: "a1", Version: 10}, {Node: "n2", Addr: "a2", Version: 11}}},
+	})
+	defer cleanup()
+
+	sr := createDiscoverdRepo(conf)
+	defer sr.Shutdown()
+
+	// a live event confirms a1
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 12}, []string{"a.b"}, nil)
+	sr.dropStale()
+
+	r, _ := sr.GetRouter("a.b", true)
+	if len(r.Addrs) != 1 || r.Addrs[0].Addr != "a1" || r.Addrs[0].Stale {
+		t.Fatalf("bad router: %v", r)
+	}
+}
+
+func Test_LoadRoutersConsumers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "msd")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	conf := &Config{TTL: time.Second, TombstoneTTL: time.Minute, RouterSnapshotPath: filepath.Join(dir, "routers")}
+	sr := createDiscoverdRepo(conf)
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 10}, []string{"a.b"}, []string{"a.c"})
+	sr.AddRouter(api.NodeAddr{Node: "n2", Addr: "a2", Version: 11}, []string{"a.c"}, []string{"a.b"})
+	sr.persistRouters()
+	sr.Shutdown()
+
+	sr = createDiscoverdRepo(conf)
+	defer sr.Shutdown()
+	cs := sr.ListConsumers()
+	if len(cs) != 2 || !cs[0].Stale || !cs[1].Stale {
+		t.Fatalf("bad consumers: %v", cs)
+	}
+
+	// a live event gossiped without the consumers confirms a1
+	sr.AddRouterWithoutConsumers(api.NodeAddr{Node: "n1", Addr: "a1", Version: 12}, []string{"a.b"})
+	sr.dropStale()
+
+	cs = sr.ListConsumers()
+	if len(cs) != 1 || cs[0].Addr != "a1" || cs[0].Stale || cs[0].Services[0] != "a.c" {
+		t.Fatalf("bad consumers: %v", cs)
+	}
+}
+
+func Test_LoadRoutersLegacySnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "msd")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "routers")
+	buf := `[{"service":"a.b","addrs":[{"node":"n1","addr":"a1","version":10}]}]`
+	if err := ioutil.WriteFile(path, []byte(buf), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	sr := createDiscoverdRepo(&Config{TTL: time.Second, TombstoneTTL: time.Minute, RouterSnapshotPath: path})
+	defer sr.Shutdown()
+	if r, exist := sr.GetRouter("a.b", true); !exist || len(r.Addrs) != 1 {
+		t.Fatalf("bad router: %v", r)
+	}
+}
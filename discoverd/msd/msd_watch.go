@@ -0,0 +1,118 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+)
+
+const (
+	// watchBufSize is the number of router events buffered for a watcher.
+	// A watcher which falls behind is closed, its client has to fetch the
+	// router table again and re-subscribe.
+	watchBufSize = 64
+)
+
+// RouterWatcher streams the router changes of the services consumed by
+// a local micro app.
+type RouterWatcher struct {
+	addr    string
+	all     bool
+	eventCh chan api.RouterEvent
+	closed  bool
+}
+
+// EventCh returns the channel the router events are delivered on. It is
+// closed when the watcher is stopped.
+func (w *RouterWatcher) EventCh() <-chan api.RouterEvent {
+	return w.eventCh
+}
+
+// Watch subscribes to the router changes of the app at addr. Only passing
+// instances are streamed unless all is set.
+func (s *DiscoverdRepo) Watch(addr string, all bool) *RouterWatcher {
+	s.logger.Printf("[INFO] ds.msd: Watching router table of app:%s", addr)
+	w := &RouterWatcher{
+		addr:    addr,
+		all:     all,
+		eventCh: make(chan api.RouterEvent, watchBufSize),
+	}
+
+	s.rtLock.Lock()
+	defer s.rtLock.Unlock()
+	select {
+	case <-s.stopCh:
+		w.close()
+	default:
+		s.watchers[w] = struct{}{}
+	}
+	return w
+}
+
+// Unwatch stops the watcher.
+func (s *DiscoverdRepo) Unwatch(w *RouterWatcher) {
+	s.rtLock.Lock()
+	defer s.rtLock.Unlock()
+	delete(s.watchers, w)
+	w.close()
+}
+
+// notifyWatchers must be called with the rtLock held, zones are listed by
+// nodeZones before taking it.
+func (s *DiscoverdRepo) notifyWatchers(changed []string, zones map[string]string) {
+	if len(s.watchers) == 0 {
+		return
+	}
+
+	cs := make(map[string]struct{}, len(changed))
+	for _, ms := range changed {
+		cs[ms] = struct{}{}
+	}
+
+	for w := range s.watchers {
+		ma, found := s.apps.Get(w.addr)
+		if !found {
+			continue
+		}
+
+		var rt *api.RouterTable
+		app := ma.(*api.MicroApp)
+		for _, ms := range app.Consumers {
+			if _, exist := cs[ms]; !exist {
+				continue
+			}
+			if rt == nil {
+				rt = s.calcRouterTable(w.addr, w.all, zones)
+			}
+
+			router, exist := s.consumedRouter(app, ms, w.all, zones)
+			if !exist {
+				router = api.Router{Service: ms, Addrs: []api.NodeAddr{}}
+			}
+
+			select {
+			case w.eventCh <- api.RouterEvent{Service: ms, Router: router, Checksum: rt.Checksum}:
+			default:
+				s.logger.Printf("[WARN] ds.msd: Watcher of app:%s is falling behind, closing it", w.addr)
+				delete(s.watchers, w)
+				w.close()
+			}
+			if w.closed {
+				break
+			}
+		}
+	}
+}
+
+// closeWatchers must be called with the rtLock held.
+func (s *DiscoverdRepo) closeWatchers() {
+	for w := range s.watchers {
+		delete(s.watchers, w)
+		w.close()
+	}
+}
+
+func (w *RouterWatcher) close() {
+	if !w.closed {
+		w.closed = true
+		close(w.eventCh)
+	}
+}
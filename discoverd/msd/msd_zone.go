@@ -0,0 +1,51 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"github.com/bluefw/blued/discoverd/check"
+)
+
+// nodeZones returns the zone of every node of the cluster, nil if
+// zone-aware routing is off. It lists the members of serf, so it is called
+// once per router table and always before taking the rtLock: serf holds
+// its member lock while delivering the events which take the rtLock.
+func (s *DiscoverdRepo) nodeZones() map[string]string {
+	if s.zoneTag == "" {
+		return nil
+	}
+	return s.cluster.NodeTags(s.zoneTag)
+}
+
+// zoneRouter returns a copy of the router with the instances in the zone
+// of the local node, or the router itself if the zone of the local node is
+// unknown or has fewer than zoneMinInstances passing instances. zones is
+// the zone of every node, as returned by nodeZones.
+func (s *DiscoverdRepo) zoneRouter(r api.Router, zones map[string]string) api.Router {
+	zone, exist := zones[s.cluster.LocalNode()]
+	if !exist {
+		return r
+	}
+
+	addrs := make([]api.NodeAddr, 0, len(r.Addrs))
+	passing := 0
+	for _, na := range r.Addrs {
+		if z, exist := zones[na.Node]; !exist || z != zone {
+			continue
+		}
+		addrs = append(addrs, na)
+		if na.Status == "" || na.Status == check.HealthPassing {
+			passing++
+		}
+	}
+	if passing < s.zoneMinInstances {
+		return r
+	}
+	if len(addrs) == len(r.Addrs) {
+		return r
+	}
+	return api.Router{
+		Service:  r.Service,
+		Addrs:    addrs,
+		Checksum: s.calcChecksum(r.Service, addrs),
+	}
+}
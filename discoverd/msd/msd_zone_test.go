@@ -0,0 +1,150 @@
+package msd
+
+import (
+	"github.com/bluefw/blued/discoverd/api"
+	"testing"
+	"time"
+)
+
+// zoneCluster is a loopbackCluster whose nodes carry zones, it counts the
+// listings of the zones.
+type zoneCluster struct {
+	loopbackCluster
+	zones map[string]string
+	calls int
+}
+
+func (c *zoneCluster) NodeTags(tag string) map[string]string {
+	c.calls++
+	return c.zones
+}
+
+func createZoneRepo(minInstances int) (*DiscoverdRepo, *zoneCluster) {
+	zc := &zoneCluster{
+		loopbackCluster: loopbackCluster{node: "node1"},
+		zones:           map[string]string{"node1": "z1", "n1": "z1", "n2": "z2", "n3": "z1"},
+	}
+	zc.repo = NewDiscoverdRepo(zc, &Config{
+		TTL:              time.Minute,
+		TombstoneTTL:     time.Minute,
+		ZoneTag:          "zone",
+		ZoneMinInstances: minInstances,
+	}, nil)
+	return zc.repo, zc
+}
+
+func Test_ZoneRouting(t *testing.T) {
+	sr, zc := createZoneRepo(1)
+	sr.Register(&api.MicroApp{Addr: "c1", Consumers: []string{"a.b", "a.c"}})
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 1}, []string{"a.b"}, nil)
+	sr.AddRouter(api.NodeAddr{Node: "n2", Addr: "a2", Version: 1}, []string{"a.b", "a.c"}, nil)
+
+	zc.calls = 0
+	rt := sr.GetRouterTable("c1", false)
+	if zc.calls != 1 {
+		t.Fatalf("zones listed %d times for a router table", zc.calls)
+	}
+	if len(rt.Routers) != 2 {
+		t.Fatalf("bad router table: %v", rt)
+	}
+	for _, r := range rt.Routers {
+		switch r.Service {
+		case "a.b":
+			// the provider in the zone of the local node only
+			if len(r.Addrs) != 1 || r.Addrs[0].Addr != "a1" {
+				t.Fatalf("bad router: %v", r)
+			}
+		case "a.c":
+			// no provider in the zone, every provider
+			if len(r.Addrs) != 1 || r.Addrs[0].Addr != "a2" {
+				t.Fatalf("bad router: %v", r)
+			}
+		}
+	}
+}
+
+func Test_ZoneMinInstances(t *testing.T) {
+	sr, _ := createZoneRepo(2)
+	sr.Register(&api.MicroApp{Addr: "c1", Consumers: []string{"a.b"}})
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 1}, []string{"a.b"}, nil)
+	sr.AddRouter(api.NodeAddr{Node: "n2", Addr: "a2", Version: 1}, []string{"a.b"}, nil)
+	sr.AddRouter(api.NodeAddr{Node: "n3", Addr: "a3", Status: "critical", Version: 1}, []string{"a.b"}, nil)
+
+	// a single passing provider in the zone, the other zones help out
+	rt := sr.GetRouterTable("c1", false)
+	if len(rt.Routers) != 1 || len(rt.Routers[0].Addrs) != 2 {
+		t.Fatalf("bad router table: %v", rt)
+	}
+
+	sr.AddRouter(api.NodeAddr{Node: "n3", Addr: "a3", Version: 2}, []string{"a.b"}, nil)
+	rt = sr.GetRouterTable("c1", false)
+	if len(rt.Routers) != 1 || len(rt.Routers[0].Addrs) != 2 {
+		t.Fatalf("bad router table: %v", rt)
+	}
+	for _, na := range rt.Routers[0].Addrs {
+		if na.Addr == "a2" {
+			t.Fatalf("provider out of the zone is routed to: %v", rt)
+		}
+	}
+}
+
+func Test_ZoneRoutingOff(t *testing.T) {
+	sr := createDiscoverdRepo(nil)
+	sr.Register(&api.MicroApp{Addr: "c1", Consumers: []string{"a.b"}})
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 1}, []string{"a.b"}, nil)
+	sr.AddRouter(api.NodeAddr{Node: "n2", Addr: "a2", Version: 1}, []string{"a.b"}, nil)
+
+	rt := sr.GetRouterTable("c1", false)
+	if len(rt.Routers) != 1 || len(rt.Routers[0].Addrs) != 2 {
+		t.Fatalf("bad router table: %v", rt)
+	}
+}
+
+// lockCluster is a zoneCluster which counts the listings of the zones
+// made with the rtLock held, serf may hold its member lock while waiting
+// for it.
+type lockCluster struct {
+	zoneCluster
+	locked int
+}
+
+func (c *lockCluster) NodeTags(tag string) map[string]string {
+	acquired := make(chan struct{})
+	go func() {
+		c.repo.rtLock.Lock()
+		c.repo.rtLock.Unlock()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(100 * time.Millisecond):
+		c.locked++
+	}
+	return c.zoneCluster.NodeTags(tag)
+}
+
+func Test_ZoneNotifyOutsideLock(t *testing.T) {
+	lc := &lockCluster{zoneCluster: zoneCluster{
+		loopbackCluster: loopbackCluster{node: "node1"},
+		zones:           map[string]string{"node1": "z1", "n1": "z1"},
+	}}
+	lc.repo = NewDiscoverdRepo(lc, &Config{TTL: time.Minute, TombstoneTTL: time.Minute, ZoneTag: "zone"}, nil)
+	sr := lc.repo
+	sr.Register(&api.MicroApp{Addr: "c1", Consumers: []string{"a.b"}})
+	w := sr.Watch("c1", false)
+	defer sr.Unwatch(w)
+
+	sr.AddRouter(api.NodeAddr{Node: "n1", Addr: "a1", Version: 1}, []string{"a.b"}, nil)
+	sr.RemoveRouter("a1", 2)
+	if lc.locked != 0 {
+		t.Fatalf("zones listed %d times with the rtLock held", lc.locked)
+	}
+	select {
+	case e := <-w.EventCh():
+		if e.Service != "a.b" || len(e.Router.Addrs) != 1 {
+			t.Fatalf("bad event: %v", e)
+		}
+	default:
+		t.Fatal("watcher not notified")
+	}
+}
@@ -1,28 +1,17 @@
 package discoverd
 
 import (
+	"github.com/bluefw/blued/discoverd/kv"
 	"github.com/bluefw/blued/discoverd/msd"
 	"github.com/braintree/manners"
 	"github.com/gin-gonic/gin"
 	"log"
+	"net/http"
 )
 
-func StartRestServer(addr string, repo *msd.DiscoverdRepo, logger *log.Logger, shutdownCh chan struct{}) {
-	rs := msd.NewServiceResource(repo, logger)
-
-	router := gin.Default()
-	router.PUT("/msd/register", func(c *gin.Context) {
-		rs.RegMicroApp(c)
-	})
-
-	router.GET("/msd/fresh/:addr", func(c *gin.Context) {
-		rs.Refresh(c)
-	})
-
-	router.GET("/msd/fetch/:addr", func(c *gin.Context) {
-		rs.GetRouterTable(c)
-	})
-
+func StartRestServer(addr string, repo *msd.DiscoverdRepo, store *kv.KVStore, metrics http.Handler,
+	logger *log.Logger, shutdownCh chan struct{}) {
+	router := newRouter(msd.NewServiceResource(repo, logger), kv.NewKVResource(store, logger), metrics)
 	go func() {
 		err := manners.ListenAndServe(addr, router)
 		close(shutdownCh)
@@ -30,6 +19,53 @@ func StartRestServer(addr string, repo *msd.DiscoverdRepo, logger *log.Logger, s
 	}()
 }
 
+func newRouter(rs *msd.ServiceResource, kr *kv.KVResource, metrics http.Handler) *gin.Engine {
+	router := gin.Default()
+
+	// The v1 API addresses apps with the addr query parameter.
+	v1 := router.Group("/v1")
+	v1.PUT("/msd/register", rs.RegMicroApp)
+	v1.DELETE("/msd/deregister", rs.DeregMicroApp)
+	v1.PUT("/msd/maint", rs.Maintenance)
+	v1.GET("/msd/refresh", rs.Refresh)
+	v1.GET("/msd/fetch", rs.GetRouterTable)
+	v1.GET("/msd/watch", rs.WatchRouterTable)
+	catalogRoutes(v1, rs)
+	kvRoutes(v1, kr)
+
+	// The routes before v1 take the addr base64 encoded in the path, they
+	// are kept for the apps using them.
+	router.PUT("/msd/register", msd.Legacy(rs.RegMicroApp))
+	router.DELETE("/msd/deregister/:addr", msd.LegacyAddr(rs.DeregMicroApp))
+	router.PUT("/msd/maint", msd.Legacy(rs.Maintenance))
+	router.PUT("/msd/maint/:addr", msd.LegacyAddr(rs.Maintenance))
+	router.GET("/msd/fresh/:addr", msd.LegacyAddr(rs.Refresh))
+	router.GET("/msd/fetch/:addr", msd.LegacyAddr(rs.GetRouterTable))
+	router.GET("/msd/watch/:addr", msd.LegacyAddr(rs.WatchRouterTable))
+
+	if metrics != nil {
+		router.GET("/metrics", func(c *gin.Context) {
+			metrics.ServeHTTP(c.Writer, c.Request)
+		})
+	}
+	return router
+}
+
+func catalogRoutes(g *gin.RouterGroup, rs *msd.ServiceResource) {
+	g.GET("/catalog/services", rs.ListServices)
+	g.GET("/catalog/service/:service", rs.ServiceInstances)
+	g.GET("/catalog/apps", rs.ListMicroApps)
+	g.GET("/catalog/node/:node", rs.NodeServices)
+	g.GET("/catalog/deps", rs.Dependencies)
+	g.GET("/catalog/deps/:service", rs.ServiceDependencies)
+}
+
+func kvRoutes(g *gin.RouterGroup, kr *kv.KVResource) {
+	g.GET("/kv/*key", kr.Get)
+	g.PUT("/kv/*key", kr.Put)
+	g.DELETE("/kv/*key", kr.Delete)
+}
+
 func ShutdownRestServer() bool {
 	return manners.Close()
 }
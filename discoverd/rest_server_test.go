@@ -7,16 +7,42 @@ import (
 	"github.com/bluefw/blued/discoverd/msd"
 	"github.com/stretchr/testify/assert"
 	"log"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 )
 
+// loopbackCluster applies the registrations of the repo to itself, as
+// serf delivers the user events to the node sending them.
+type loopbackCluster struct {
+	cluster.MockCluster
+	repo *msd.DiscoverdRepo
+}
+
+func (c *loopbackCluster) RegisterService(ss *api.AppService, coalesce bool) error {
+	c.repo.AddRouter(api.NodeAddr{
+		Node:    c.LocalNode(),
+		Addr:    ss.Addr,
+		Status:  ss.Status,
+		Weight:  ss.Weight,
+		Version: ss.Version,
+	}, ss.Services, ss.Consumers)
+	return nil
+}
+
+func createRepo() *msd.DiscoverdRepo {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	lc := &loopbackCluster{}
+	lc.repo = msd.NewDiscoverdRepo(lc, &msd.Config{TTL: time.Minute, TombstoneTTL: time.Minute}, logger)
+	return lc.repo
+}
+
 func TestRegMicroApp(t *testing.T) {
 	logger := log.New(os.Stderr, "", log.LstdFlags)
-	cluster := cluster.NewMockCluster()
-	repo := msd.NewDiscoverdRepo(logger, &cluster)
-	hs := NewHttpServer(":8341", logger, repo)
-	go hs.Start()
+	repo := createRepo()
+	hs := httptest.NewServer(newRouter(msd.NewServiceResource(repo, logger), nil, nil))
+	defer hs.Close()
 
 	ma := &api.MicroApp{
 		Addr:      "http://a.com:8080/rs",
@@ -24,9 +50,9 @@ func TestRegMicroApp(t *testing.T) {
 		Consumers: []string{"a.b", "a.c"},
 	}
 
-	makeRequest("PUT", "http://127.0.0.1:8341/msd/register", ma)
+	makeRequest("PUT", hs.URL+"/msd/register", ma)
 	addr := base64.StdEncoding.EncodeToString([]byte("http://a.com:8080/rs"))
-	url := "http://127.0.0.1:8341/msd/router/" + addr
+	url := hs.URL + "/msd/fetch/" + addr
 	r, err := makeRequest("GET", url, nil)
 	if err != nil {
 		t.Error("Error")
@@ -36,6 +62,70 @@ func TestRegMicroApp(t *testing.T) {
 	processResponseEntity(r, &rt, 200)
 
 	assert.Equal(t, 2, len(rt.Routers))
-	assert.Equal(t, ma.Addr, rt.Routers[0].Addrs[0])
-	assert.Equal(t, ma.Addr, rt.Routers[1].Addrs[0])
+	assert.Equal(t, ma.Addr, rt.Routers[0].Addrs[0].Addr)
+	assert.Equal(t, ma.Addr, rt.Routers[1].Addrs[0].Addr)
+}
+
+func TestLegacyStatusCodes(t *testing.T) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	repo := createRepo()
+	hs := httptest.NewServer(newRouter(msd.NewServiceResource(repo, logger), nil, nil))
+	defer hs.Close()
+
+	addr := base64.StdEncoding.EncodeToString([]byte("http://a.com:8080/rs"))
+	cases := []struct {
+		method string
+		path   string
+		entity interface{}
+		status int
+	}{
+		{"GET", "/msd/fetch/" + addr, nil, 200},
+		{"GET", "/v1/msd/fetch?addr=http%3A%2F%2Fa.com%3A8080%2Frs", nil, 404},
+		{"PUT", "/msd/register", &api.MicroApp{Providers: []string{"a.b"}}, 400},
+		{"PUT", "/v1/msd/register", &api.MicroApp{Providers: []string{"a.b"}}, 422},
+		{"GET", "/v1/catalog/services", nil, 200},
+		{"GET", "/catalog/services", nil, 404},
+		{"GET", "/msd/fresh/" + addr, nil, 202},
+		{"GET", "/v1/msd/refresh?addr=http%3A%2F%2Fa.com%3A8080%2Frs", nil, 404},
+	}
+	for _, c := range cases {
+		r, err := makeRequest(c.method, hs.URL+c.path, c.entity)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		r.Body.Close()
+		if r.StatusCode != c.status {
+			t.Fatalf("%s %s: status %d, expected %d", c.method, c.path, r.StatusCode, c.status)
+		}
+	}
+}
+
+func TestRegisterConflict(t *testing.T) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	repo := createRepo()
+	hs := httptest.NewServer(newRouter(msd.NewServiceResource(repo, logger), nil, nil))
+	defer hs.Close()
+
+	// the app is announced by another agent
+	repo.AddRouter(api.NodeAddr{Node: "n2", Addr: "http://a.com:8080/rs", Version: 1}, []string{"a.b"}, nil)
+
+	ma := &api.MicroApp{Addr: "http://a.com:8080/rs", Providers: []string{"a.b"}}
+	r, err := makeRequest("PUT", hs.URL+"/v1/msd/register", ma)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var e api.Error
+	if err := processResponseEntity(r, &e, 409); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(t, api.ErrConflict, e.Code)
+
+	// until it unregisters
+	repo.RemoveRouter(ma.Addr, 2)
+	r, err = makeRequest("PUT", hs.URL+"/v1/msd/register", ma)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r.Body.Close()
+	assert.Equal(t, 201, r.StatusCode)
 }
@@ -114,6 +114,20 @@ func (c *cache) Refresh(k string, d time.Duration) bool {
 	return true
 }
 
+// Replace the object of an item only if it already exists, keeping its
+// expiration. Returns false otherwise.
+func (c *cache) Replace(k string, x interface{}) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.items[k]
+	if !found || item.Expired() {
+		return false
+	}
+	item.Object = x
+	return true
+}
+
 // Get an item from the cache. Returns the item or nil, and a bool indicating
 // whether the key was found.
 func (c *cache) Get(k string) (interface{}, bool) {
@@ -253,6 +267,19 @@ func (c *cache) Items() map[string]*Item {
 	return c.items
 }
 
+// Returns a copy of the items in the cache, made under the read lock. It may
+// include items that have expired, but have not yet been cleaned up. Unlike
+// Items(), it can be ranged over while the cache is updated.
+func (c *cache) CopyItems() map[string]Item {
+	c.RLock()
+	m := make(map[string]Item, len(c.items))
+	for k, v := range c.items {
+		m[k] = *v
+	}
+	c.RUnlock()
+	return m
+}
+
 // Returns the number of items in the cache. This may include items that have
 // expired, but have not yet been cleaned up. Equivalent to len(c.Items()).
 func (c *cache) ItemCount() int {
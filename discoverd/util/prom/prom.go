@@ -0,0 +1,190 @@
+// Package prom is a go-metrics sink keeping the metrics of the agent in
+// memory, to be scraped by Prometheus in its text exposition format.
+package prom
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/armon/go-metrics"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+	typeSummary = "summary"
+
+	// ContentType is the content type of the text exposition format.
+	ContentType = "text/plain; version=0.0.4"
+)
+
+// series is a metric and a set of labels. Counters and gauges only use
+// the value, samples their count and sum.
+type series struct {
+	labels string
+	value  float64
+	count  uint64
+}
+
+type family struct {
+	typ    string
+	series map[string]*series
+}
+
+// Sink accumulates the metrics it receives: counters are cumulative,
+// gauges retain their last value and samples are exposed as summaries
+// of their count and sum.
+type Sink struct {
+	families map[string]*family
+	lock     sync.Mutex
+}
+
+func NewSink() *Sink {
+	return &Sink{
+		families: make(map[string]*family),
+	}
+}
+
+func (s *Sink) SetGauge(key []string, val float32) {
+	s.SetGaugeWithLabels(key, val, nil)
+}
+
+func (s *Sink) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.series(typeGauge, key, labels).value = float64(val)
+}
+
+func (s *Sink) EmitKey(key []string, val float32) {
+	s.SetGauge(key, val)
+}
+
+func (s *Sink) IncrCounter(key []string, val float32) {
+	s.IncrCounterWithLabels(key, val, nil)
+}
+
+func (s *Sink) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.series(typeCounter, key, labels).value += float64(val)
+}
+
+func (s *Sink) AddSample(key []string, val float32) {
+	s.AddSampleWithLabels(key, val, nil)
+}
+
+func (s *Sink) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ss := s.series(typeSummary, key, labels)
+	ss.value += float64(val)
+	ss.count++
+}
+
+// series returns the series of a metric, created if needed. A key used
+// with another type of metric gets a name suffixed with the type. It
+// must be called with the lock held.
+func (s *Sink) series(typ string, key []string, labels []metrics.Label) *series {
+	name := metricName(key)
+	f, exist := s.families[name]
+	if exist && f.typ != typ {
+		name = name + "_" + typ
+		f, exist = s.families[name]
+	}
+	if !exist {
+		f = &family{
+			typ:    typ,
+			series: make(map[string]*series),
+		}
+		s.families[name] = f
+	}
+
+	ls := formatLabels(labels)
+	ss, exist := f.series[ls]
+	if !exist {
+		ss = &series{labels: ls}
+		f.series[ls] = ss
+	}
+	return ss
+}
+
+// WriteTo writes the metrics in the Prometheus text format, sorted by
+// name and labels.
+func (s *Sink) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+
+	s.lock.Lock()
+	names := make([]string, 0, len(s.families))
+	for name := range s.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := s.families[name]
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, f.typ)
+
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ss := f.series[k]
+			if f.typ == typeSummary {
+				fmt.Fprintf(&buf, "%s_sum%s %v\n", name, ss.labels, ss.value)
+				fmt.Fprintf(&buf, "%s_count%s %d\n", name, ss.labels, ss.count)
+			} else {
+				fmt.Fprintf(&buf, "%s%s %v\n", name, ss.labels, ss.value)
+			}
+		}
+	}
+	s.lock.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP serves the metrics to Prometheus.
+func (s *Sink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	s.WriteTo(w)
+}
+
+// metricName joins the parts of a key with underscores, replacing the
+// characters Prometheus does not allow in a name.
+func metricName(key []string) string {
+	return sanitize(strings.Join(key, "_"))
+}
+
+func sanitize(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// labelEscaper escapes label values as the text format expects, unlike
+// Go quoting it leaves the other characters as they are.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels returns the labels as {name="value",...}, sorted by name.
+func formatLabels(labels []metrics.Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	ls := make([]string, len(labels))
+	for i, l := range labels {
+		ls[i] = sanitize(l.Name) + `="` + labelEscaper.Replace(l.Value) + `"`
+	}
+	sort.Strings(ls)
+	return "{" + strings.Join(ls, ",") + "}"
+}
@@ -0,0 +1,47 @@
+package prom
+
+import (
+	"bytes"
+	"github.com/armon/go-metrics"
+	"testing"
+)
+
+func TestSink_WriteTo(t *testing.T) {
+	s := NewSink()
+	s.IncrCounter([]string{"serf-agent", "discoverd", "register"}, 1)
+	s.IncrCounter([]string{"serf-agent", "discoverd", "register"}, 2)
+	s.SetGaugeWithLabels([]string{"discoverd", "router"}, 3,
+		[]metrics.Label{{Name: "service", Value: "com.foo"}})
+	s.SetGaugeWithLabels([]string{"discoverd", "router"}, 4,
+		[]metrics.Label{{Name: "service", Value: "com.foo"}})
+	s.AddSample([]string{"agent", "invoke", "1s.sh"}, 1.5)
+	s.AddSample([]string{"agent", "invoke", "1s.sh"}, 0.5)
+
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expected := `# TYPE agent_invoke_1s_sh summary
+agent_invoke_1s_sh_sum 2
+agent_invoke_1s_sh_count 2
+# TYPE discoverd_router gauge
+discoverd_router{service="com.foo"} 4
+# TYPE serf_agent_discoverd_register counter
+serf_agent_discoverd_register 3
+`
+	if buf.String() != expected {
+		t.Fatalf("bad:\n%s", buf.String())
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	labels := []metrics.Label{
+		{Name: "service", Value: "café \\ \"x\"\ny"},
+		{Name: "a-b", Value: "v"},
+	}
+	expected := `{a_b="v",service="café \\ \"x\"\ny"}`
+	if got := formatLabels(labels); got != expected {
+		t.Fatalf("bad: %s", got)
+	}
+}
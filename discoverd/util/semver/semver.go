@@ -0,0 +1,261 @@
+// Package semver parses semantic versions and matches them against
+// version constraints such as ">=1.2.0, <2.0.0" or "^1.4".
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version. Missing minor and patch numbers are 0,
+// build metadata is ignored.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	Pre   string
+}
+
+// Parse parses a version such as 1.2.3, v1.2 or 1.2.3-rc.1+build.5.
+func Parse(s string) (*Version, error) {
+	ver, _, err := parse(s)
+	return ver, err
+}
+
+// parse parses a version and returns how many of its major, minor and
+// patch numbers are given.
+func parse(s string) (*Version, int, error) {
+	v := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if idx := strings.IndexByte(v, '+'); idx >= 0 {
+		v = v[:idx]
+	}
+
+	var ver Version
+	if idx := strings.IndexByte(v, '-'); idx >= 0 {
+		ver.Pre = v[idx+1:]
+		v = v[:idx]
+		if ver.Pre == "" {
+			return nil, 0, fmt.Errorf("invalid version %q", s)
+		}
+	}
+
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return nil, 0, fmt.Errorf("invalid version %q", s)
+	}
+	nums := []*int{&ver.Major, &ver.Minor, &ver.Patch}
+	for idx, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, 0, fmt.Errorf("invalid version %q", s)
+		}
+		*nums[idx] = n
+	}
+	return &ver, len(parts), nil
+}
+
+// Compare returns -1, 0 or 1 whether v is lower, equal or greater than o.
+// A pre-release is lower than its release.
+func (v *Version) Compare(o *Version) int {
+	if c := compareInt(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	return comparePre(v.Pre, o.Pre)
+}
+
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// comparePre compares the dot separated identifiers of pre-releases,
+// numerically if both are numbers.
+func comparePre(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for idx := 0; idx < len(as) && idx < len(bs); idx++ {
+		an, aerr := strconv.Atoi(as[idx])
+		bn, berr := strconv.Atoi(bs[idx])
+		var c int
+		switch {
+		case aerr == nil && berr == nil:
+			c = compareInt(an, bn)
+		case aerr == nil:
+			c = -1
+		case berr == nil:
+			c = 1
+		default:
+			c = strings.Compare(as[idx], bs[idx])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(as), len(bs))
+}
+
+// Constraint is a set of version ranges. A version matches the constraint
+// if it matches every term of one of its ranges.
+type Constraint struct {
+	ranges [][]term
+}
+
+// term is an operator and its version. The ~ and ^ terms match the
+// versions from ver up to max excluded.
+type term struct {
+	op  string
+	ver *Version
+	max *Version
+}
+
+var operators = []string{">=", "<=", "!=", ">", "<", "=", "~", "^"}
+
+// ParseConstraint parses a constraint. Ranges are separated by "||", the
+// terms of a range by commas or spaces. A term is an operator among =, !=,
+// >, >=, <, <=, ~ and ^ followed by a version, no operator means =. As
+// with npm and Cargo, ~ allows patch updates (~1.2.3 is <1.3.0, ~1 is
+// <2.0.0) and ^ allows the updates keeping the leftmost non-zero number
+// (^1.2.3 is <2.0.0, ^0.2 is <0.3.0, ^0.0.3 is <0.0.4).
+func ParseConstraint(s string) (*Constraint, error) {
+	var c Constraint
+	for _, rs := range strings.Split(s, "||") {
+		var r []term
+		fields := strings.FieldsFunc(rs, func(c rune) bool { return c == ',' || c == ' ' })
+		for idx := 0; idx < len(fields); idx++ {
+			ts := fields[idx]
+			// an operator may be separated from its version by spaces
+			if isOperator(ts) && idx+1 < len(fields) {
+				idx++
+				ts += fields[idx]
+			}
+			t, err := parseTerm(ts)
+			if err != nil {
+				return nil, err
+			}
+			r = append(r, t)
+		}
+		if len(r) == 0 {
+			return nil, fmt.Errorf("invalid constraint %q", s)
+		}
+		c.ranges = append(c.ranges, r)
+	}
+	return &c, nil
+}
+
+func isOperator(s string) bool {
+	for _, o := range operators {
+		if s == o {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTerm(s string) (term, error) {
+	op := ""
+	for _, o := range operators {
+		if strings.HasPrefix(s, o) {
+			op = o
+			break
+		}
+	}
+	ver, given, err := parse(s[len(op):])
+	if err != nil {
+		return term{}, err
+	}
+	t := term{op: op, ver: ver}
+
+	// the number bumped to get the upper bound of ~ and ^, the numbers
+	// after it are zeroed
+	bump := -1
+	switch op {
+	case "~":
+		bump = 1
+		if given == 1 {
+			bump = 0
+		}
+	case "^":
+		bump = given - 1
+		for idx, n := range []int{ver.Major, ver.Minor, ver.Patch}[:given] {
+			if n != 0 {
+				bump = idx
+				break
+			}
+		}
+	}
+	if bump >= 0 {
+		nums := []int{ver.Major, ver.Minor, ver.Patch}
+		nums[bump]++
+		for idx := bump + 1; idx < len(nums); idx++ {
+			nums[idx] = 0
+		}
+		t.max = &Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}
+	}
+	return t, nil
+}
+
+// Check tells whether the version matches the constraint.
+func (c *Constraint) Check(v *Version) bool {
+	for _, r := range c.ranges {
+		match := true
+		for _, t := range r {
+			if !t.check(v) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func (t term) check(v *Version) bool {
+	c := v.Compare(t.ver)
+	switch t.op {
+	case "", "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case "~", "^":
+		return c >= 0 && v.Compare(t.max) < 0
+	}
+	return false
+}
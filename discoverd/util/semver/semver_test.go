@@ -0,0 +1,111 @@
+package semver
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+		ok  bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"v1.2", "1.2.0", true},
+		{"2", "2.0.0", true},
+		{"1.2.3-rc.1+build.5", "1.2.3-rc.1", true},
+		{"1.2.3.4", "", false},
+		{"1.x", "", false},
+		{"1.2.3-", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		v, err := Parse(c.in)
+		if (err == nil) != c.ok {
+			t.Fatalf("%q: err: %v", c.in, err)
+		}
+		if err == nil && v.String() != c.out {
+			t.Fatalf("%q: bad: %s", c.in, v)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		c    int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+	}
+	for _, c := range cases {
+		a, _ := Parse(c.a)
+		b, _ := Parse(c.b)
+		if got := a.Compare(b); got != c.c {
+			t.Fatalf("%s <=> %s: %d", c.a, c.b, got)
+		}
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	cases := []struct {
+		constraint string
+		version    string
+		match      bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"=1.2.3", "1.2.4", false},
+		{"!=1.2.3", "1.2.4", true},
+		{">=1.2.0, <2.0.0", "1.9.0", true},
+		{">=1.2.0, <2.0.0", "2.0.0", false},
+		{">=1.2.0 <2.0.0", "1.1.9", false},
+		{"~1.2.0", "1.2.9", true},
+		{"~1.2.0", "1.3.0", false},
+		{"^1.2", "1.9.0", true},
+		{"^1.2", "1.1.0", false},
+		{"^1.2", "2.0.0", false},
+		{"<1.0.0 || >=2.0.0", "2.1.0", true},
+		{"<1.0.0 || >=2.0.0", "1.5.0", false},
+		{">= 1.2.0", "1.2.0", true},
+		{">= 1.2.0, < 2.0.0", "2.0.0", false},
+		{"^ 1.2", "1.3.0", true},
+		{"~1.2.3", "1.2.2", false},
+		{"~1.2", "1.2.0", true},
+		{"~1.2", "1.3.0", false},
+		{"~1", "1.9.0", true},
+		{"~1", "2.0.0", false},
+		{"^1.2.3", "1.9.9", true},
+		{"^0.2", "0.2.5", true},
+		{"^0.2", "0.3.0", false},
+		{"^0.2.3", "0.2.2", false},
+		{"^0.0.3", "0.0.3", true},
+		{"^0.0.3", "0.0.4", false},
+		{"^0.0", "0.0.9", true},
+		{"^0.0", "0.1.0", false},
+		{"^0", "0.9.0", true},
+		{"^0", "1.0.0", false},
+		{"^1", "1.9.0", true},
+		{"^1", "2.0.0", false},
+	}
+	for _, c := range cases {
+		cons, err := ParseConstraint(c.constraint)
+		if err != nil {
+			t.Fatalf("%q: err: %v", c.constraint, err)
+		}
+		v, _ := Parse(c.version)
+		if cons.Check(v) != c.match {
+			t.Fatalf("%q %q: expected %v", c.constraint, c.version, c.match)
+		}
+	}
+
+	for _, s := range []string{"", ">=", ">= ", ">=1.x", "1.0 ||", ">= >= 1.0"} {
+		if _, err := ParseConstraint(s); err == nil {
+			t.Fatalf("%q: expected error", s)
+		}
+	}
+}